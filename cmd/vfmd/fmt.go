@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/akavel/vfmd.v1"
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdfmt"
+)
+
+// markdownExts lists extensions of files formatted when walking directories.
+var markdownExts = []string{".md", ".markdown", ".mdown", ".mkd"}
+
+type fmtOptions struct {
+	list, write, diff, github bool
+}
+
+func runFmt(args []string) error {
+	var opt fmtOptions
+	flags := flag.NewFlagSet("vfmd fmt", flag.ExitOnError)
+	flags.BoolVar(&opt.list, "l", false, "list files whose formatting differs")
+	flags.BoolVar(&opt.write, "w", false, "write result to (source) file instead of standard output")
+	flags.BoolVar(&opt.diff, "d", false, "display diffs instead of rewriting files")
+	flags.BoolVar(&opt.github, "github", false, "use supported Github-flavored Markdown extensions")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: vfmd fmt [flags] [path ...]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		if opt.write {
+			return fmt.Errorf("cannot use -w with standard input")
+		}
+		src, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			return err
+		}
+		return fmtFile(os.Stdout, "<standard input>", src, opt)
+	}
+	// Like gofmt, report errors and continue with other files.
+	failed := false
+	report := func(err error) {
+		fmt.Fprintln(os.Stderr, err)
+		failed = true
+	}
+	for _, path := range flags.Args() {
+		filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				report(err)
+				return nil
+			}
+			if info.IsDir() || (p != path && !isMarkdown(p)) {
+				return nil
+			}
+			src, err := ioutil.ReadFile(p)
+			if err == nil {
+				err = fmtFile(os.Stdout, p, src, opt)
+			}
+			if err != nil {
+				report(err)
+			}
+			return nil
+		})
+	}
+	if failed {
+		return fmt.Errorf("some files could not be formatted")
+	}
+	return nil
+}
+
+func isMarkdown(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	for _, e := range markdownExts {
+		if ext == e {
+			return true
+		}
+	}
+	return false
+}
+
+// fmtFile formats src, and reports or writes the result as requested in opt.
+// The result, the list of files, or the diffs are written to out.
+func fmtFile(out io.Writer, path string, src []byte, opt fmtOptions) error {
+	res, err := format(src, opt.github)
+	if err != nil {
+		return fmt.Errorf("%s: %s", path, err)
+	}
+	if !opt.list && !opt.write && !opt.diff {
+		_, err = out.Write(res)
+		return err
+	}
+	if bytes.Equal(src, res) {
+		return nil
+	}
+	if opt.list {
+		fmt.Fprintln(out, path)
+	}
+	if opt.write {
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+		err = ioutil.WriteFile(path, res, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+	}
+	if opt.diff {
+		data, err := diffFiles(path, src, res)
+		if err != nil {
+			return fmt.Errorf("computing diff: %s", err)
+		}
+		fmt.Fprintf(out, "diff %s vfmd/%s\n%s", path, path, data)
+	}
+	return nil
+}
+
+// diffFiles returns the differences between src and res in the unified
+// format, named path and vfmd/path, as computed by the diff command (like
+// gofmt used to do).
+func diffFiles(path string, src, res []byte) ([]byte, error) {
+	f1, err := writeTemp(src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f1)
+	f2, err := writeTemp(res)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f2)
+	data, err := exec.Command("diff", "-u", f1, f2).CombinedOutput()
+	if len(data) == 0 {
+		// diff exits with status 1 if the files differ.
+		return nil, err
+	}
+	// Replace the names of the temporary files in the header.
+	lines := bytes.SplitN(data, []byte("\n"), 3)
+	if len(lines) == 3 && bytes.HasPrefix(lines[0], []byte("--- ")) && bytes.HasPrefix(lines[1], []byte("+++ ")) {
+		data = append([]byte(fmt.Sprintf("--- %s\n+++ vfmd/%s\n", path, path)), lines[2]...)
+	}
+	return data, nil
+}
+
+func writeTemp(data []byte) (string, error) {
+	f, err := ioutil.TempFile("", "vfmd")
+	if err != nil {
+		return "", err
+	}
+	_, err = f.Write(data)
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+var bom = []byte{0xEF, 0xBB, 0xBF}
+
+// format returns src formatted in canonical vfmd style. Blocks which don't
+// need formatting are copied from src byte by byte.
+func format(src []byte, github bool) ([]byte, error) {
+	prep, smap, err := vfmd.QuickPrepMap(bytes.NewReader(src))
+	if err != nil {
+		return nil, err
+	}
+	blockDet, spanDet := detectors(github)
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, blockDet, spanDet)
+	if err != nil {
+		return nil, err
+	}
+	// lineEnd returns the offset in src after the LF ending the line
+	// (including its CR, if any), or the end of src for the last line.
+	// Position returns the offset of the LF for any position past it.
+	lineEnd := func(line int) int {
+		end, _ := smap.Position(line, len(prep))
+		if end >= 0 && end < len(src) && src[end] == '\n' {
+			end++
+		}
+		return end
+	}
+	r := mdfmt.Renderer{
+		Original: func(raw md.Raw) []byte {
+			// Position would skip an ignored CR at the beginning of a
+			// line, so the start is found at the end of the previous one.
+			start, _ := smap.Position(0, 0)
+			if first := raw[0].Line; first > 0 {
+				start = lineEnd(first - 1)
+			}
+			end := lineEnd(raw[len(raw)-1].Line)
+			if start < 0 || end < 0 {
+				return nil
+			}
+			return src[start:end]
+		},
+	}
+	buf := bytes.NewBuffer(nil)
+	// A Byte-Order-Mark is ignored by the parser, but kept in the output.
+	if bytes.HasPrefix(src, bom) {
+		buf.Write(bom)
+	}
+	err = r.Render(buf, blocks)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+)
+
+func TestFormat(test *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{{
+		input:    "# Title\r\n\r\nSome text\r\n\r\n* a\r\n* b\r\n",
+		expected: "# Title\r\n\r\nSome text\r\n\r\n* a\r\n* b\r\n",
+	}, {
+		input:    "Title\r\n=====\r\n\r\nSome\ttext\r\n",
+		expected: "# Title\n\r\nSome\ttext\r\n",
+	}, {
+		input:    "\xEF\xBB\xBF# Title\n\ntext\n",
+		expected: "\xEF\xBB\xBF# Title\n\ntext\n",
+	}, {
+		input:    "\xEF\xBB\xBFTitle\n=====\n",
+		expected: "\xEF\xBB\xBF# Title\n",
+	}, {
+		input:    "last line\r\nwithout LF",
+		expected: "last line\r\nwithout LF\n",
+	}}
+	for _, c := range cases {
+		res, err := format([]byte(c.input), false)
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if string(res) != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, string(res)))
+		}
+	}
+}
+
+func TestFmtFileList(test *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"# Title\r\n\r\nSome text\r\n", ""},
+		{"\xEF\xBB\xBF# Title\n", ""},
+		{"Title\n=====\n", "a.md\n"},
+	}
+	for _, c := range cases {
+		out := bytes.NewBuffer(nil)
+		err := fmtFile(out, "a.md", []byte(c.input), fmtOptions{list: true})
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if out.String() != c.expected {
+			test.Errorf("case %q expected %q, got %q", c.input, c.expected, out.String())
+		}
+	}
+}
+
+func TestFmtFileDiff(test *testing.T) {
+	if _, err := exec.LookPath("diff"); err != nil {
+		test.Skip("diff command not found")
+	}
+	out := bytes.NewBuffer(nil)
+	err := fmtFile(out, "a.md", []byte("# Title\n\nTitle\n=====\n"), fmtOptions{diff: true})
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := "diff a.md vfmd/a.md\n--- a.md\n+++ vfmd/a.md\n@@ -1,4 +1,3 @@\n # Title\n \n-Title\n-=====\n+# Title\n"
+	if out.String() != expected {
+		test.Errorf("expected vs. got DIFF:\n%s", diff.Diff(expected, out.String()))
+	}
+
+	out.Reset()
+	err = fmtFile(out, "a.md", []byte("# Title\n"), fmtOptions{diff: true})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if out.Len() > 0 {
+		test.Errorf("expected no diff for a formatted file, got:\n%s", out.String())
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+)
+
+// terminalWidth returns the number of columns of the terminal, as found in
+// $COLUMNS or reported by stty, or 80 if unknown.
+func terminalWidth() int {
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	cmd := exec.Command("stty", "size")
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	if err == nil {
+		fields := strings.Fields(string(out))
+		if len(fields) == 2 {
+			if n, err := strconv.Atoi(fields[1]); err == nil && n > 0 {
+				return n
+			}
+		}
+	}
+	return 80
+}
+
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// runPager shows text in $PAGER, or in "less -R" if not set. The pager is
+// run directly, with its arguments split at whitespace. If it can't be found,
+// text is written to w.
+func runPager(text io.Reader, w io.Writer) error {
+	args := strings.Fields(os.Getenv("PAGER"))
+	if len(args) == 0 {
+		args = []string{"less", "-R"}
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		_, err = io.Copy(w, text)
+		return err
+	}
+	cmd := exec.Command(path, args[1:]...)
+	cmd.Stdin = text
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
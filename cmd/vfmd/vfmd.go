@@ -4,16 +4,24 @@ import (
 	"bytes"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 
 	"gopkg.in/akavel/vfmd.v1"
 	"gopkg.in/akavel/vfmd.v1/mdblock"
 	"gopkg.in/akavel/vfmd.v1/mdspan"
 	"gopkg.in/akavel/vfmd.v1/x/mdgithub"
+	"gopkg.in/akavel/vfmd.v1/x/mdterm"
+	"gopkg.in/akavel/vfmd.v1/x/mdtext"
 )
 
 func main() {
-	err := run()
+	var err error
+	if len(os.Args) > 1 && os.Args[1] == "fmt" {
+		err = runFmt(os.Args[2:])
+	} else {
+		err = run()
+	}
 	if err != nil {
 		fmt.Fprintln(os.Stderr, "error:", err)
 		os.Exit(1)
@@ -23,11 +31,24 @@ func main() {
 func run() error {
 	var (
 		in     = flag.String("i", "-", "path to input Markdown document, or - for standard input")
-		out    = flag.String("o", "-", "path to output HTML document, or - for standard output")
+		out    = flag.String("o", "-", "path to output document, or - for standard output")
 		github = flag.Bool("github", false, "use supported Github-flavored Markdown extensions")
+		format = flag.String("format", "", "output format: html, text, or term (styled for a terminal); default: term with -page, html otherwise")
+		width  = flag.Int("width", -1, "maximum line length for text and term formats, or 0 for no wrapping; default: 72 for text, terminal width for term")
+		color  = flag.Bool("color", true, "use colors and styles in term format, if the output is a terminal or pager, and $NO_COLOR is not set")
+		page   = flag.Bool("page", false, "show the output in a pager ($PAGER, or less)")
 		// TODO(akavel): tmpl = flag.String("t", "<!doctype html><html lang=en><head><meta charset=utf-8><title></title></head><body>\n{{.}}\n</body></html>", "template for the output HTML document") // see: http://www.brucelawson.co.uk/2010/a-minimal-html5-document/
 	)
 	flag.Parse()
+	if *format == "" {
+		*format = "html"
+		if *page {
+			*format = "term"
+		}
+	}
+	if *format != "html" && *format != "text" && *format != "term" {
+		return fmt.Errorf("unknown output format: %q", *format)
+	}
 
 	var err error
 	inf, outf := os.Stdin, os.Stderr
@@ -46,17 +67,7 @@ func run() error {
 		defer outf.Close()
 	}
 
-	var blockDet []mdblock.Detector
-	var spanDet []mdspan.Detector
-	if *github {
-		blockDet = append(blockDet, mdblock.DefaultDetectors[:2]...)
-		blockDet = append(blockDet, mdgithub.FencedCodeBlock{})
-		blockDet = append(blockDet, mdblock.DefaultDetectors[2:]...)
-		spanDet = append(spanDet, mdspan.DefaultDetectors[:2]...)
-		spanDet = append(spanDet, mdgithub.StrikeThrough{})
-		spanDet = append(spanDet, mdspan.DefaultDetectors[2:]...)
-	}
-
+	blockDet, spanDet := detectors(*github)
 	prep, err := vfmd.QuickPrep(inf)
 	if err != nil {
 		return err
@@ -65,9 +76,48 @@ func run() error {
 	if err != nil {
 		return err
 	}
-	err = vfmd.QuickHTML(outf, blocks)
+	var w io.Writer = outf
+	buf := bytes.NewBuffer(nil)
+	if *page {
+		w = buf
+	}
+	switch *format {
+	case "text":
+		if *width < 0 {
+			*width = 72
+		}
+		err = mdtext.Renderer{Width: *width}.Render(w, blocks)
+	case "term":
+		if *width < 0 {
+			*width = terminalWidth()
+		}
+		noColor := !*color || os.Getenv("NO_COLOR") != "" || (!*page && !isTerminal(outf))
+		err = mdterm.Renderer{Width: *width, NoColor: noColor}.Render(w, blocks)
+	default:
+		err = vfmd.QuickHTML(w, blocks)
+	}
 	if err != nil {
 		return err
 	}
+	if *page {
+		return runPager(buf, outf)
+	}
 	return nil
 }
+
+// detectors returns the block and span detectors to use, or nil for defaults.
+func detectors(github bool) ([]mdblock.Detector, []mdspan.Detector) {
+	if !github {
+		return nil, nil
+	}
+	var blockDet []mdblock.Detector
+	var spanDet []mdspan.Detector
+	blockDet = append(blockDet, mdblock.DefaultDetectors[:2]...)
+	blockDet = append(blockDet, mdgithub.FencedCodeBlock{})
+	blockDet = append(blockDet, mdgithub.Table{})
+	blockDet = append(blockDet, mdblock.DefaultDetectors[2:]...)
+	spanDet = append(spanDet, mdspan.DefaultDetectors[:2]...)
+	spanDet = append(spanDet, mdgithub.StrikeThrough{})
+	spanDet = append(spanDet, mdspan.DefaultDetectors[2:]...)
+	return blockDet, spanDet
+}
@@ -0,0 +1,234 @@
+package vfmd
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdfmt"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+)
+
+func quickFmt(input string) (string, error) {
+	prep, err := QuickPrep(strings.NewReader(input))
+	if err != nil {
+		return "", err
+	}
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(nil)
+	err = mdfmt.QuickRender(buf, blocks)
+	return buf.String(), err
+}
+
+func TestFmt(test *testing.T) {
+	cases := []struct {
+		input, expected string
+	}{{
+		"Title\n=====\n\nSub #\n---\n### Atx ###\n",
+		"# Title\n\n## Sub # ##\n### Atx\n",
+	}, {
+		"_a_ __b__ *c* 2\\*3 \\_d\\_\n",
+		"*a* **b** *c* 2\\*3 \\_d\\_\n",
+	}, {
+		"+ one\n+ two\n\n    more\n\n- three\n",
+		"* one\n* two\n\n  more\n\n- three\n",
+	}, {
+		"3. x\n7.  y\n",
+		"3. x\n4. y\n",
+	}, {
+		"> quote\nlazy\n>\n> > nested\n",
+		"> quote\n> lazy\n>\n> > nested\n",
+	}, {
+		"\tcode\n\n\n    more\n",
+		"    code\n\n\n    more\n",
+	}, {
+		"a\n- - -\nb\n",
+		"a\n* * *\nb\n",
+	}, {
+		"``a`b`` [l](http://x.org \"t\") ![i] [r][]\n\n[r]: http://r.org\n",
+		"``a`b`` [l](http://x.org \"t\") ![i] [r][]\n\n[r]: http://r.org\n",
+	}, {
+		"<div>\n*raw*\n</div>\n\npara\n",
+		"<div>\n*raw*\n</div>\n\npara\n",
+	}, {
+		" # x\n> y\n1. z\n- w\n===\n",
+		"\\# x\n\\> y\n1\\. z\n\\- w\n\\===\n",
+	}, {
+		"1. a\n\n\n2. b\n",
+		"1. a\n\n\n2. b\n",
+	}, {
+		">\n\n1. * \n",
+		">\n\n1. * \n",
+	}, {
+		"a*b*_c_ d\n_   *\nsnake_case a_b_ c `x <z &a\n",
+		"a\\*b\\*\\_c\\_ d\n\\_   \\*\nsnake_case a_b\\_ c \\`x \\<z \\&a\n",
+	}, {
+		"para\n<!-- x -->\n---\n",
+		"para\n\n## <!-- x -->\n",
+	}, {
+		"* \n  item\n*  \\\n",
+		"* \n  item\n*  \\\n",
+	}}
+	for _, c := range cases {
+		output, err := quickFmt(c.input)
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if output != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, output))
+		}
+	}
+}
+
+// TestFmtRoundTrip checks that formatted Markdown renders to the same HTML
+// as the original text, and is not changed when formatted again.
+func TestFmtRoundTrip(test *testing.T) {
+	inputs := []string{
+		"Hello *world*!\n\n* a\n\n  b\n* c\n\n1. x\n2. y\n",
+		"> # Quote\n>\n>     code\n> * in\n>   > deep\n\ntail\n",
+		"* a\n* b\n\n\n* c\n",
+		"a *b **c** d* \\*e\\* `` ` `` <http://auto.example/>  \nnext &amp; <b>tag</b>\n",
+		"[link *text*][ref] ![alt \\[x\\]](img.png 'T')\n\n[ref]: http://example.org/ \"Title\"\n",
+		"    one\n\n\n    two\n",
+		"1. a\n   1. b\n      * c\n\n         code\n",
+		" # x\n\\- y\n2015. z\n- - -\n\n* a\n  \\- b\n  c\n  ---\n",
+		"1. a\n\n\n2. b\n\n> 1. c\n>\n>\n> 2. d\n",
+		"* a\n* \n* b\n\n>\n\n1. * \n2. > \n",
+		"a*b*_c_ d\n\n*em*_u_\n\n_   *\n\nsnake_case `x [y] <z &a &#1; a_b_ c\n",
+		"para\n<!-- x -->\n---\n",
+		"* \n  item\n* b\n\n*  \\\n",
+	}
+	for _, input := range inputs {
+		output, err := quickFmt(input)
+		if err != nil {
+			test.Errorf("case %q error: %s", input, err)
+			continue
+		}
+		html1, err1 := quickHTML(input, mdhtml.Renderer{})
+		html2, err2 := quickHTML(output, mdhtml.Renderer{})
+		if err1 != nil || err2 != nil {
+			test.Errorf("case %q HTML errors: %v, %v", input, err1, err2)
+			continue
+		}
+		if strings.Join(strings.Fields(html1), " ") != strings.Join(strings.Fields(html2), " ") {
+			test.Errorf("case %q output %q HTML DIFF:\n%s",
+				input, output, diff.Diff(html1, html2))
+		}
+		tree1, tree2 := quickTree(input), quickTree(output)
+		if tree1 != tree2 {
+			test.Errorf("case %q output %q tree DIFF:\n%s",
+				input, output, diff.Diff(tree1, tree2))
+		}
+		again, err := quickFmt(output)
+		if err != nil || again != output {
+			test.Errorf("case %q not idempotent (err=%v) DIFF:\n%s",
+				input, err, diff.Diff(output, again))
+		}
+	}
+}
+
+// quickTree returns the tags parsed from input, one per line, without the
+// details which formatting may change, like the raw source text, bullets,
+// setext vs. ATX header style or the number of blank lines between blocks
+// (which the HTML comparison covers).
+func quickTree(input string) string {
+	prep, err := QuickPrep(strings.NewReader(input))
+	if err != nil {
+		return err.Error()
+	}
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		return err.Error()
+	}
+	text := func(p md.Prose) string {
+		buf := []byte{}
+		for _, r := range p {
+			buf = append(buf, r.Bytes...)
+		}
+		return string(buf)
+	}
+	lines := []string{}
+	for i := 0; i < len(blocks); i++ {
+		line := ""
+		switch t := blocks[i].(type) {
+		case md.NullBlock:
+			i++ // md.End
+			continue
+		case md.Prose:
+			if n := len(lines); n > 0 && strings.HasPrefix(lines[n-1], "Prose ") {
+				// Adjacent texts may be split differently.
+				lines[n-1] += text(t)
+				continue
+			}
+			line = "Prose " + text(t)
+		case md.CodeBlock:
+			line = "CodeBlock " + text(t.Prose)
+		case md.AtxHeaderBlock:
+			line = fmt.Sprintf("Header %d", t.Level)
+		case md.SetextHeaderBlock:
+			line = fmt.Sprintf("Header %d", t.Level)
+		case md.OrderedListBlock:
+			line = "OrderedListBlock " + strings.TrimSpace(string(t.Starter.Bytes))
+		case md.Link:
+			line = fmt.Sprintf("Link %q %q %q", t.ReferenceID, t.URL, t.Title)
+		case md.Image:
+			t.RawEnd = nil
+			line = fmt.Sprintf("%#v", t)
+		case md.Code:
+			line = fmt.Sprintf("Code %q", t.Code)
+		case md.HTMLTag, md.Entity, md.Emphasis, md.AutomaticLink:
+			line = fmt.Sprintf("%#v", t)
+		case md.ReferenceResolutionBlock:
+			line = fmt.Sprintf("ReferenceResolutionBlock %q %q %q", t.ReferenceID, t.URL, t.Title)
+		default:
+			line = fmt.Sprintf("%T", t)
+		}
+		lines = append(lines, line)
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
+
+func TestFmtOriginal(test *testing.T) {
+	input := "Title\n=====\n\n\tcode\r\n\n* a\r\n* b\n\n\n\npara\twith tab"
+	expected := "# Title\n\n\tcode\r\n\n* a\r\n* b\n\npara\twith tab\n"
+	prep, smap, err := QuickPrepMap(strings.NewReader(input))
+	if err != nil {
+		test.Fatal(err)
+	}
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	r := mdfmt.Renderer{
+		Original: func(raw md.Raw) []byte {
+			start, _ := smap.Position(raw[0].Line, 0)
+			end, _ := smap.Position(raw[len(raw)-1].Line+1, 0)
+			if end < 0 {
+				end = len(input)
+			}
+			return []byte(input[start:end])
+		},
+	}
+	buf := bytes.NewBuffer(nil)
+	err = r.Render(buf, blocks)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if buf.String() != expected {
+		test.Errorf("expected vs. got DIFF:\n%s", diff.Diff(expected, buf.String()))
+	}
+}
+
+func TestFmtRenderError(test *testing.T) {
+	testRenderError(test, mdfmt.Renderer{}.Render)
+}
@@ -0,0 +1,234 @@
+//go:build go1.18
+// +build go1.18
+
+package vfmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/mdspan"
+	"gopkg.in/akavel/vfmd.v1/mdtree"
+	"gopkg.in/akavel/vfmd.v1/x/mdfmt"
+	"gopkg.in/akavel/vfmd.v1/x/mdgithub"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+	"gopkg.in/akavel/vfmd.v1/x/mdterm"
+	"gopkg.in/akavel/vfmd.v1/x/mdtext"
+)
+
+var fuzzSeeds = []string{
+	"",
+	"# header\n\npara *em* **strong** `code` [link](http://x.org \"t\") ![img][ref]\n\n[ref]: /x.png\n",
+	"> quote\n> * item\n>\n>   1. nested\n\n    code\n\n- - -\n",
+	"<div>\nhtml\n</div>\n\n<!-- comment -->\n\na <b>b</b> &amp; &#169;\n",
+	"text\n===\n\n* a\n\n* b\n  c\n",
+	"[a [b] c](d) [x]: ] [ [y] [] <http://auto.link> a  \nb\\*",
+	"~~strike~~ ~~\n\n```go\ncode\n```\n\n| a | b |\n|:--|--:|\n| c \\| d | e |\n",
+	"\xEF\xBB\xBF\ttab\r\ncr\r\xFF\xC5\n",
+	"*a **b* c** _d __e_ f__ ~~",
+	"[",
+	"]",
+	"1. a\n2. b\n\n\n3. c",
+}
+
+func fuzzDetectors(github bool) (mdblock.Detectors, []mdspan.Detector) {
+	if !github {
+		return nil, nil
+	}
+	var blockDet mdblock.Detectors
+	var spanDet []mdspan.Detector
+	blockDet = append(blockDet, mdblock.DefaultDetectors[:2]...)
+	blockDet = append(blockDet, mdgithub.FencedCodeBlock{}, mdgithub.Table{})
+	blockDet = append(blockDet, mdblock.DefaultDetectors[2:]...)
+	spanDet = append(spanDet, mdspan.DefaultDetectors[:2]...)
+	spanDet = append(spanDet, mdgithub.StrikeThrough{})
+	spanDet = append(spanDet, mdspan.DefaultDetectors[2:]...)
+	return blockDet, spanDet
+}
+
+// FuzzRender checks that no input makes the parser or the renderers panic.
+func FuzzRender(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s), false)
+		f.Add([]byte(s), true)
+	}
+	f.Fuzz(func(t *testing.T, input []byte, github bool) {
+		prep, err := QuickPrep(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		detectors, spanDetectors := fuzzDetectors(github)
+		blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, detectors, spanDetectors)
+		if err != nil {
+			return
+		}
+		for _, mode := range []mdblock.Mode{mdblock.BlocksOnly, mdblock.TopBlocks} {
+			mdblock.QuickParse(bytes.NewReader(prep), mode, detectors, spanDetectors)
+		}
+		mdhtml.Renderer{AutoHeadingID: true}.Render(ioutil.Discard, blocks)
+		mdhtml.Renderer{HTML: mdhtml.HTMLEscape}.Render(ioutil.Discard, blocks)
+		mdfmt.QuickRender(ioutil.Discard, blocks)
+		mdtext.Renderer{Width: 20}.Render(ioutil.Discard, blocks)
+		mdterm.Renderer{Width: 20}.Render(ioutil.Discard, blocks)
+	})
+}
+
+// FuzzTree checks that the tags of any document can be converted to a tree
+// and back without changes, and walked without errors.
+func FuzzTree(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s), false)
+		f.Add([]byte(s), true)
+	}
+	f.Fuzz(func(t *testing.T, input []byte, github bool) {
+		prep, err := QuickPrep(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		detectors, spanDetectors := fuzzDetectors(github)
+		blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, detectors, spanDetectors)
+		if err != nil {
+			return
+		}
+		root, err := mdtree.Build(blocks)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if flat := root.Flatten(); !reflect.DeepEqual(flat, blocks) {
+			t.Fatalf("Flatten(Build(x)) != x, DIFF:\n%s", diff.Diff(spew.Sdump(blocks), spew.Sdump(flat)))
+		}
+		entered, left := 0, 0
+		err = mdtree.Walk(blocks, mdtree.Funcs{
+			EnterFunc: func(md.Tag) mdtree.Action { entered++; return mdtree.Continue },
+			LeaveFunc: func(md.Tag) mdtree.Action { left++; return mdtree.Continue },
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entered != left || entered != len(blocks)-countEnds(blocks) {
+			t.Fatalf("walked %d/%d of %d tags", entered, left, len(blocks)-countEnds(blocks))
+		}
+	})
+}
+
+func countEnds(tags []md.Tag) int {
+	n := 0
+	for _, t := range tags {
+		if (t == md.End{}) {
+			n++
+		}
+	}
+	return n
+}
+
+// FuzzReparse checks that updating the tags of a document with Reparse after
+// replacing some of its lines gives the same result as parsing the new
+// document from scratch.
+func FuzzReparse(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s), uint(1), uint(2), []byte("changed\n"), false)
+		f.Add([]byte(s), uint(0), uint(0), []byte("> new\n\n"), true)
+	}
+	// Capacities of slices differ in reused tags with shifted lines.
+	dump := spew.ConfigState{Indent: " ", DisableCapacities: true}
+	f.Fuzz(func(t *testing.T, input []byte, from, to uint, insert []byte, github bool) {
+		prep, err := QuickPrep(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(prep) > 0 && prep[len(prep)-1] != '\n' {
+			// Otherwise, the inserted lines could join the last one.
+			prep = append(prep, '\n')
+		}
+		inserted, err := QuickPrep(bytes.NewReader(insert))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(inserted) > 0 && inserted[len(inserted)-1] != '\n' {
+			inserted = append(inserted, '\n')
+		}
+		oldLines := mdblock.Lines(prep)
+		if to > uint(len(oldLines)) {
+			to = uint(len(oldLines))
+		}
+		if from > to {
+			from = to
+		}
+		newLines := mdblock.Lines(inserted)
+		edit := mdblock.Edit{From: int(from), To: int(to), N: len(newLines)}
+		lines := append([][]byte{}, oldLines[:from]...)
+		lines = append(lines, newLines...)
+		lines = append(lines, oldLines[to:]...)
+
+		detectors, spanDetectors := fuzzDetectors(github)
+		old, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, detectors, spanDetectors)
+		if err != nil {
+			return
+		}
+		expected, err := mdblock.QuickParse(bytes.NewReader(bytes.Join(lines, nil)), mdblock.BlocksAndSpans, detectors, spanDetectors)
+		if err != nil {
+			return
+		}
+		tags, _, err := mdblock.Reparse(old, lines, edit, mdblock.BlocksAndSpans, detectors, spanDetectors)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dump.Sdump(tags) != dump.Sdump(expected) {
+			t.Fatalf("edit %+v: Reparse vs. QuickParse DIFF:\n%s",
+				edit, diff.Diff(dump.Sdump(expected), dump.Sdump(tags)))
+		}
+	})
+}
+
+// FuzzSpans checks that no input makes the span parser panic.
+func FuzzSpans(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	_, spanDetectors := fuzzDetectors(true)
+	f.Fuzz(func(t *testing.T, input []byte) {
+		mdspan.Parse(input, nil)
+		mdspan.Parse(input, spanDetectors)
+		mdspan.ParseRegion(md.Region{{Line: 0, Bytes: input}}, nil)
+	})
+}
+
+// FuzzReader checks that Reader returns the same data as QuickPrepMap, and
+// that positions of lines are consistent with SourceMap.
+func FuzzReader(f *testing.F) {
+	for _, s := range fuzzSeeds {
+		f.Add([]byte(s))
+	}
+	f.Fuzz(func(t *testing.T, input []byte) {
+		expected, m, err := QuickPrepMap(bytes.NewReader(input))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r := NewReader(bytes.NewReader(input))
+		var result []byte
+		for i := 0; ; i++ {
+			line, err := r.ReadLine()
+			if err != nil {
+				break
+			}
+			result = append(result, line.Bytes...)
+			for b := 0; b <= len(line.Bytes); b++ {
+				o1, c1 := line.Position(b)
+				o2, c2 := m.Position(i, b)
+				if o1 != o2 || c1 != c2 {
+					t.Fatalf("line %d byte %d: expected %d, %d got %d, %d", i, b, o2, c2, o1, c1)
+				}
+			}
+		}
+		if !bytes.Equal(result, expected) {
+			t.Fatalf("expected %q got %q", expected, result)
+		}
+	})
+}
@@ -2,6 +2,8 @@ package vfmd
 
 import (
 	"bytes"
+	"errors"
+	"io"
 	"io/ioutil"
 	"path"
 	"path/filepath"
@@ -12,33 +14,22 @@ import (
 	"github.com/davecgh/go-spew/spew"
 	"github.com/kylelemons/godebug/diff"
 
+	"gopkg.in/akavel/vfmd.v1/md"
 	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
 )
 
 /*
 TODO(akavel): missing tests:
 {"block_level/atx_header/span_in_text.md"},
-{"block_level/paragraph/blanks_within_html_comment.md"},
-{"block_level/paragraph/blanks_within_html_tag.md"},
-{"block_level/paragraph/blanks_within_verbatim_html.md"},
-{"block_level/paragraph/html_block.md"},
-{"block_level/paragraph/html_comment.md"},
-{"block_level/paragraph/md_within_html.md"},
-{"block_level/paragraph/misnested_html.md"},
-{"block_level/paragraph/non_phrasing_html_tag.md"},
-{"block_level/paragraph/phrasing_html_tag.md"},
 {"block_level/setext_header/span_in_text.md"},
 
 // {"span_level/automatic_links/angle_brackets_in_link.md"},
 // {"span_level/automatic_links/mail_url_without_angle_brackets.md"},
 // {"span_level/automatic_links/url_schemes.md"},
 // {"span_level/automatic_links/url_special_chars.md"},
-// {"span_level/code/vs_html.md"},
-// {"span_level/emphasis/vs_html.md"},
 // {"span_level/emphasis/with_punctuation.md"},
 // {"span_level/image/image_title.md"},
-// {"span_level/image/vs_html.md"},
-// {"span_level/link/vs_html.md"},
 
 */
 
@@ -131,12 +122,21 @@ func TestHTMLFiles(test *testing.T) {
 		{"block_level/ordered_list/with_codeblock.md"},
 		{"block_level/ordered_list/with_para.md"},
 		{"block_level/ordered_list/with_setext_header.md"},
+		{"block_level/paragraph/blanks_within_html_comment.md"},
+		{"block_level/paragraph/blanks_within_html_tag.md"},
+		{"block_level/paragraph/blanks_within_verbatim_html.md"},
 		{"block_level/paragraph/followed_by_atx_header.md"},
 		{"block_level/paragraph/followed_by_blockquote.md"},
 		{"block_level/paragraph/followed_by_codeblock.md"},
 		{"block_level/paragraph/followed_by_horizontal_rule.md"},
 		{"block_level/paragraph/followed_by_list.md"},
 		{"block_level/paragraph/followed_by_setext_header.md"},
+		{"block_level/paragraph/html_block.md"},
+		{"block_level/paragraph/html_comment.md"},
+		{"block_level/paragraph/md_within_html.md"},
+		{"block_level/paragraph/misnested_html.md"},
+		{"block_level/paragraph/non_phrasing_html_tag.md"},
+		{"block_level/paragraph/phrasing_html_tag.md"},
 		{"block_level/paragraph/simple_para.md"},
 		{"block_level/paragraph/two_paras_1blank.md"},
 		{"block_level/paragraph/two_paras_2blank.md"},
@@ -190,6 +190,7 @@ func TestHTMLFiles(test *testing.T) {
 		{"span_level/code/end_of_codespan.md"},
 		{"span_level/code/multiline.md"},
 		{"span_level/code/vs_emph.md"},
+		{"span_level/code/vs_html.md"},
 		{"span_level/code/vs_image.md"},
 		{"span_level/code/vs_link.md"},
 		{"span_level/code/well_formed.md"},
@@ -199,6 +200,7 @@ func TestHTMLFiles(test *testing.T) {
 		{"span_level/emphasis/nested_homogenous.md"},
 		{"span_level/emphasis/opening_and_closing_tags.md"},
 		{"span_level/emphasis/simple.md"},
+		{"span_level/emphasis/vs_html.md"},
 		{"span_level/emphasis/within_whitespace.md"},
 		{"span_level/image/direct_link.md"},
 		{"span_level/image/direct_link_with_2separating_spaces.md"},
@@ -227,6 +229,7 @@ func TestHTMLFiles(test *testing.T) {
 		{"span_level/image/url_whitespace.md"},
 		{"span_level/image/vs_code.md"},
 		{"span_level/image/vs_emph.md"},
+		{"span_level/image/vs_html.md"},
 		{"span_level/image/within_link.md"},
 		{"span_level/link/direct_link.md"},
 		{"span_level/link/direct_link_with_2separating_spaces.md"},
@@ -256,6 +259,7 @@ func TestHTMLFiles(test *testing.T) {
 		{"span_level/link/url_whitespace.md"},
 		{"span_level/link/vs_code.md"},
 		{"span_level/link/vs_emph.md"},
+		{"span_level/link/vs_html.md"},
 		{"span_level/link/vs_image.md"},
 
 		{"text_processing/utf8/invalid_unicode.md"},
@@ -331,7 +335,7 @@ func TestHTMLFiles(test *testing.T) {
 		}
 
 		buf := bytes.NewBuffer(nil)
-		err = QuickHTML(buf, blocks)
+		err = mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough}.Render(buf, blocks)
 		if err != nil {
 			test.Error(err)
 			continue
@@ -358,3 +362,352 @@ func simplifyHtml(buf []byte) []byte {
 	buf = bytes.TrimSpace(buf)
 	return buf
 }
+
+func quickHTML(input string, r mdhtml.Renderer) (string, error) {
+	prep, _ := QuickPrep(strings.NewReader(input))
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		return "", err
+	}
+	buf := bytes.NewBuffer(nil)
+	err = r.Render(buf, blocks)
+	return buf.String(), err
+}
+
+func TestHTMLRawHTML(test *testing.T) {
+	cases := []struct {
+		input    string
+		r        mdhtml.Renderer
+		expected string
+	}{{
+		input:    "Press <kbd>Ctrl</kbd>+<kbd>C</kbd>.",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: "<p>Press <kbd>Ctrl</kbd>+<kbd>C</kbd>.</p>\n",
+	}, {
+		input:    `An <img src="a_b_c.png" alt='*x*' /> here`,
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: `<p>An <img src="a_b_c.png" alt='*x*' /> here</p>` + "\n",
+	}, {
+		input:    "Not <a_b> nor `<kbd>`",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: "<p>Not &lt;a_b&gt; nor <code>&lt;kbd&gt;</code></p>\n",
+	}, {
+		input:    "Some <!-- hidden\n\n*comment* --> text",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: "<p>Some <!-- hidden\n\n*comment* --> text</p>\n",
+	}, {
+		input:    "<details>\n<summary>More</summary>\n\nSome **text**\n\n</details>\n",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: "<details>\n<summary>More</summary>\n\n<p>Some <strong>text</strong></p>\n</details>\n",
+	}, {
+		input:    "Text\n<div>\n*not emphasis*\n</div>\n",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: "<p>Text</p>\n<div>\n*not emphasis*\n</div>\n",
+	}, {
+		input:    "<pre>\nsome\n\n  code\n</pre>\nText\n",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: "<pre>\nsome\n\n  code\n</pre>\n<p>Text</p>\n",
+	}, {
+		input:    "<!-- a\n\nb -->\n",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLPassThrough},
+		expected: "<!-- a\n\nb -->\n",
+	}, {
+		input:    "Press <kbd>Ctrl</kbd>\n\n<div>x</div>\n",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLEscape},
+		expected: "<p>Press &lt;kbd&gt;Ctrl&lt;/kbd&gt;</p>\n<p>&lt;div&gt;x&lt;/div&gt;</p>\n",
+	}, {
+		input:    "Press <kbd>Ctrl</kbd>\n\n<div>x</div>\n",
+		r:        mdhtml.Renderer{HTML: mdhtml.HTMLDrop},
+		expected: "<p>Press Ctrl</p>\n",
+	}, {
+		input:    "<script>alert(1)</script>\n\nA <b onclick=\"x()\">b</b>\n",
+		expected: "<p>&lt;script&gt;alert(1)&lt;/script&gt;</p>\n\n<p>A &lt;b onclick=&#34;x()&#34;&gt;b&lt;/b&gt;</p>\n",
+	}}
+	for _, c := range cases {
+		html, err := quickHTML(c.input, c.r)
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if html != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, html))
+		}
+	}
+}
+
+func TestHTMLEntities(test *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"A&nbsp;B &mdash; &copy; 2015", "<p>A&nbsp;B &mdash; &copy; 2015</p>\n"},
+		{"&#169; &#xA9; &#XA9;", "<p>&#169; &#xA9; &#XA9;</p>\n"},
+		{"&nosuchentity; &#0; &#xD800; &#1114112;", "<p>&amp;nosuchentity; &amp;#0; &amp;#xD800; &amp;#1114112;</p>\n"},
+		{"&ampfoo; &notit; &copyright;", "<p>&amp;ampfoo; &amp;notit; &amp;copyright;</p>\n"},
+		{"AT&T & &amp \\&amp; `&amp;`", "<p>AT&amp;T &amp; &amp;amp &amp;amp; <code>&amp;amp;</code></p>\n"},
+	}
+	for _, c := range cases {
+		html, err := quickHTML(c.input, mdhtml.Renderer{})
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if html != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, html))
+		}
+	}
+}
+
+func TestHTMLHardBreak(test *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{"Roses are red,  \nViolets   \nare blue.  ", "<p>Roses are red,<br />\nViolets<br />\nare blue.</p>\n"},
+		{"No break \nhere\\\nnor `here  \nthere`", "<p>No break \nhere\\\nnor <code>here  \nthere</code></p>\n"},
+		{"a   *b*   c \nd  \ne", "<p>a   <em>b</em>   c \nd<br />\ne</p>\n"},
+	}
+	for _, c := range cases {
+		html, err := quickHTML(c.input, mdhtml.Renderer{})
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if html != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, html))
+		}
+	}
+}
+
+func TestHTMLHighlight(test *testing.T) {
+	var languages []string
+	r := mdhtml.Renderer{
+		Highlight: func(language string, code []byte) ([]byte, bool) {
+			languages = append(languages, language)
+			if bytes.HasPrefix(code, []byte("skip")) {
+				return nil, false
+			}
+			return []byte("<b>" + strings.ToUpper(string(code)) + "</b>"), true
+		},
+	}
+	input := "    x := 1\n    y\n\ntext\n\n    skip <me>\n"
+	expected := "<pre><code><b>X := 1\nY\n</b></code></pre>\n\n<p>text</p>\n<pre><code>skip &lt;me&gt;\n</code></pre>\n"
+	html, err := quickHTML(input, r)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if html != expected {
+		test.Errorf("expected vs. got DIFF:\n%s", diff.Diff(expected, html))
+	}
+	if len(languages) != 2 || languages[0] != "" || languages[1] != "" {
+		test.Errorf("unexpected languages passed to highlighter: %q", languages)
+	}
+}
+
+func TestHTMLRenderer(test *testing.T) {
+	slug := func(level int, text string) string {
+		return strings.ToLower(strings.Replace(text, " ", "-", -1))
+	}
+	refs := []md.ReferenceResolutionBlock{
+		{ReferenceID: "ext", URL: "http://ext.example/"},
+		{ReferenceID: "doc", URL: "http://overridden.example/"},
+	}
+	cases := []struct {
+		input    string
+		r        mdhtml.Renderer
+		expected string
+	}{{
+		input:    "a  \nb\n\n* * *\n\n![i](x.png)",
+		r:        mdhtml.Renderer{HTML5: true},
+		expected: "<p>a<br>\nb</p>\n<hr>\n\n<p><img src=\"x.png\" alt=\"i\"></p>\n",
+	}, {
+		input:    "one\ntwo\nthree",
+		r:        mdhtml.Renderer{Newlines: mdhtml.NewlineSpace},
+		expected: "<p>one two three</p>\n",
+	}, {
+		input:    "one\ntwo",
+		r:        mdhtml.Renderer{Newlines: mdhtml.NewlineBreak},
+		expected: "<p>one<br />\ntwo</p>\n",
+	}, {
+		input:    "# Hello *World* `x`\n\nSub &amp; *more*\n---\n",
+		r:        mdhtml.Renderer{HeadingID: slug},
+		expected: "<h1 id=\"hello-world-x\">Hello <em>World</em> <code>x</code></h1>\n\n<h2 id=\"sub-&amp;-more\">Sub &amp; <em>more</em></h2>\n",
+	}, {
+		input:    "[a][ext] [b][doc] [c][none]\n\n[doc]: http://doc.example/\n",
+		r:        mdhtml.Renderer{Refs: refs},
+		expected: "<p><a href=\"http://ext.example/\">a</a> <a href=\"http://doc.example/\">b</a> [c][none]</p>\n",
+	}}
+	for _, c := range cases {
+		html, err := quickHTML(c.input, c.r)
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if html != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, html))
+		}
+	}
+}
+
+func TestHTMLURLPolicy(test *testing.T) {
+	input := "[a](javascript:void) [b](/rel) [c](HTTPS://x.org) ![d](data:image/png;base64,AAAA) <mailto:me@x.org> [e][r]\n\n[r]: JaVaScript:x\n"
+	cases := []struct {
+		urls     *mdhtml.URLPolicy
+		expected string
+	}{{
+		urls:     nil,
+		expected: `<p>a <a href="/rel">b</a> <a href="HTTPS://x.org">c</a> d <a href="mailto:me@x.org">mailto:me@x.org</a> e</p>` + "\n",
+	}, {
+		urls:     &mdhtml.URLPolicy{},
+		expected: `<p><a href="javascript:void">a</a> <a href="/rel">b</a> <a href="HTTPS://x.org">c</a> <img src="data:image/png;base64,AAAA" alt="d" /> <a href="mailto:me@x.org">mailto:me@x.org</a> <a href="JaVaScript:x">e</a></p>` + "\n",
+	}, {
+		urls:     &mdhtml.URLPolicy{Schemes: []string{"https"}, DenyRelative: true},
+		expected: `<p>a b <a href="HTTPS://x.org">c</a> d mailto:me@x.org e</p>` + "\n",
+	}, {
+		urls: &mdhtml.URLPolicy{Filter: func(url string) (string, bool) {
+			if strings.HasPrefix(url, "/") {
+				return "https://example.com" + url, true
+			}
+			return "", false
+		}},
+		expected: `<p>a <a href="https://example.com/rel">b</a> c d mailto:me@x.org e</p>` + "\n",
+	}, {
+		urls: func() *mdhtml.URLPolicy {
+			p := mdhtml.DefaultURLPolicy()
+			p.Schemes = append(p.Schemes, "data")
+			return p
+		}(),
+		expected: `<p>a <a href="/rel">b</a> <a href="HTTPS://x.org">c</a> <img src="data:image/png;base64,AAAA" alt="d" /> <a href="mailto:me@x.org">mailto:me@x.org</a> e</p>` + "\n",
+	}}
+	for i, c := range cases {
+		html, err := quickHTML(input, mdhtml.Renderer{URLs: c.urls})
+		if err != nil {
+			test.Errorf("case %d error: %s", i, err)
+			continue
+		}
+		if html != c.expected {
+			test.Errorf("case %d expected vs. got DIFF:\n%s",
+				i, diff.Diff(c.expected, html))
+		}
+	}
+}
+
+func TestHTMLURLEscaping(test *testing.T) {
+	cases := []struct {
+		input    string
+		expected string
+	}{
+		{`<http://a.b/"onmouseover="alert(1)>`, `<p><a href="http://a.b/&#34;onmouseover=&#34;alert(1)">http://a.b/&#34;onmouseover=&#34;alert(1)</a></p>` + "\n"},
+		{`http://a.b/"onmouseover="alert(1)`, `<p><a href="http://a.b/&#34;onmouseover=&#34;alert(1">http://a.b/&#34;onmouseover=&#34;alert(1</a>)</p>` + "\n"},
+		{`<http://a.b/?x=1&y=2>`, `<p><a href="http://a.b/?x=1&amp;y=2">http://a.b/?x=1&amp;y=2</a></p>` + "\n"},
+		{`[a](<http://a.b/"onmouseover="x>)`, `<p><a href="http://a.b/%22onmouseover=%22x">a</a></p>` + "\n"},
+		{`![a](<http://a.b/"onmouseover="x>)`, `<p><img src="http://a.b/%22onmouseover=%22x" alt="a" /></p>` + "\n"},
+	}
+	for _, c := range cases {
+		html, err := quickHTML(c.input, mdhtml.Renderer{})
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if html != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, html))
+		}
+	}
+}
+
+func TestHTMLHeadingIDs(test *testing.T) {
+	cases := []struct {
+		input    string
+		r        mdhtml.Renderer
+		expected string
+	}{{
+		input: "# Hello, *World*!\n\n## Hello World\n\n# Hello world\n\nHello world\n---\n\n### ¿Qué tal? `x_y`\n\n### !!!\n",
+		r:     mdhtml.Renderer{AutoHeadingID: true},
+		expected: "<h1 id=\"hello-world\">Hello, <em>World</em>!</h1>\n\n" +
+			"<h2 id=\"hello-world-1\">Hello World</h2>\n\n" +
+			"<h1 id=\"hello-world-2\">Hello world</h1>\n\n" +
+			"<h2 id=\"hello-world-3\">Hello world</h2>\n\n" +
+			"<h3 id=\"qué-tal-x_y\">¿Qué tal? <code>x_y</code></h3>\n\n" +
+			"<h3 id=\"section\">!!!</h3>\n",
+	}, {
+		input: "# Intro {#start}\n\n## *Usage* {#use}\n\n# Start\n\n## Plain {#}\n",
+		r:     mdhtml.Renderer{AutoHeadingID: true},
+		expected: "<h1 id=\"start\">Intro</h1>\n\n" +
+			"<h2 id=\"use\"><em>Usage</em></h2>\n\n" +
+			"<h1 id=\"start-1\">Start</h1>\n\n" +
+			"<h2 id=\"plain-\">Plain {#}</h2>\n",
+	}, {
+		input: "# Start\n\n# Intro {#start}\n\n# A {#a}\n\n# B {#a}\n\n# A\n",
+		r:     mdhtml.Renderer{AutoHeadingID: true},
+		expected: "<h1 id=\"start\">Start</h1>\n\n" +
+			"<h1 id=\"start-1\">Intro</h1>\n\n" +
+			"<h1 id=\"a\">A</h1>\n\n" +
+			"<h1 id=\"a-1\">B</h1>\n\n" +
+			"<h1 id=\"a-2\">A</h1>\n",
+	}, {
+		input:    "# Intro {#start}\n",
+		expected: "<h1>Intro {#start}</h1>\n",
+	}, {
+		input: "# Fixed\n\n# Auto\n",
+		r: mdhtml.Renderer{AutoHeadingID: true, HeadingID: func(level int, text string) string {
+			if text == "Fixed" {
+				return "fixed-id"
+			}
+			return ""
+		}},
+		expected: "<h1 id=\"fixed-id\">Fixed</h1>\n\n<h1 id=\"auto\">Auto</h1>\n",
+	}}
+	for _, c := range cases {
+		html, err := quickHTML(c.input, c.r)
+		if err != nil {
+			test.Errorf("case %q error: %s", c.input, err)
+			continue
+		}
+		if html != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, html))
+		}
+	}
+}
+
+type unknownTag struct{}
+
+func TestHTMLRenderError(test *testing.T) {
+	testRenderError(test, mdhtml.Renderer{}.Render)
+}
+
+// testRenderError checks that render reports the unknown tag, and its
+// position, in a md.RenderError.
+func testRenderError(test *testing.T, render func(io.Writer, []md.Tag) error) {
+	prep, _ := QuickPrep(strings.NewReader("# head\n\npara *x*\ny\n"))
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	cases := []struct {
+		i    int // index where unknownTag is inserted
+		line int
+	}{
+		{0, 0}, // block; position taken from the following header
+		{5, 2}, // block, before the paragraph
+		{6, 2}, // span, before "para"
+	}
+	for _, c := range cases {
+		tags := append(append(append([]md.Tag{}, blocks[:c.i]...), unknownTag{}), blocks[c.i:]...)
+		err := render(ioutil.Discard, tags)
+		var rerr *md.RenderError
+		if !errors.As(err, &rerr) {
+			test.Errorf("case %d expected RenderError, got %v", c.i, err)
+			continue
+		}
+		if _, ok := rerr.Tag.(unknownTag); !ok || &rerr.Tags[0] != &tags[c.i] || rerr.Run.Line != c.line {
+			test.Errorf("case %d expected unknownTag at line %d, got %T at line %d (%d tags remaining): %s",
+				c.i, c.line, rerr.Tag, rerr.Run.Line, len(rerr.Tags), err)
+		}
+	}
+}
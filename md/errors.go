@@ -0,0 +1,60 @@
+package md
+
+import "fmt"
+
+// RenderError is returned by renderers when they fail to render a tag.
+type RenderError struct {
+	// Msg describes the error.
+	Msg string
+	// Tag is the offending tag, or nil if the tags ended unexpectedly.
+	Tag Tag
+	// Tags contains Tag followed by all the remaining tags.
+	Tags []Tag
+	// Run is the first Run found in Tags, pointing at the position of the
+	// error in the document. Its Line is -1 if not known. Column in the
+	// source can be found with vfmd.SourceMap.RunPosition.
+	Run Run
+}
+
+// RenderErrorf returns a RenderError for the first of tags, with a message
+// formatted like with fmt.Sprintf.
+func RenderErrorf(tags []Tag, format string, args ...interface{}) *RenderError {
+	e := &RenderError{
+		Msg:  fmt.Sprintf(format, args...),
+		Tags: tags,
+		Run:  Run{Line: -1},
+	}
+	if len(tags) > 0 {
+		e.Tag = tags[0]
+	}
+	for _, t := range tags {
+		if r, ok := firstRun(t); ok {
+			e.Run = r
+			break
+		}
+	}
+	return e
+}
+
+func (e *RenderError) Error() string {
+	if e.Run.Line < 0 {
+		return "vfmd: " + e.Msg
+	}
+	return fmt.Sprintf("vfmd: line %d: %s", e.Run.Line, e.Msg)
+}
+
+func firstRun(t Tag) (Run, bool) {
+	var region Region
+	switch t := t.(type) {
+	case interface{ GetRaw() Region }:
+		region = t.GetRaw()
+	case Proser:
+		region = t.GetProse()
+	case Code:
+		region = Region(t.Raw)
+	}
+	if len(region) == 0 || region[0].Line < 0 {
+		return Run{}, false
+	}
+	return region[0], true
+}
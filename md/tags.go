@@ -6,7 +6,10 @@ type Link struct {
 }
 type AutomaticLink struct{ URL, Text string }
 type Emphasis struct{ Level int }
-type Code struct{ Code []byte }
+type Code struct {
+	Code []byte
+	Raw  Raw // whole code span, including the backticks
+}
 type Image struct {
 	ReferenceID string
 	URL         string
@@ -16,6 +19,21 @@ type Image struct {
 }
 type End struct{}
 
+// HardBreak is a forced line break, e.g. at a line ending with two spaces.
+type HardBreak struct{}
+
+// HTMLTag is a fragment of inline HTML: an opening, closing or empty tag, or
+// a comment. HTML contains the verbatim source of the fragment.
+type HTMLTag struct{ HTML []byte }
+
+// Entity is an HTML character entity reference, like "&amp;", "&#38;" or
+// "&#x26;". Text contains the verbatim reference, and Runes the character(s)
+// it decodes to, or nil if the reference is not valid.
+type Entity struct {
+	Text  []byte
+	Runes []rune
+}
+
 type NullBlock struct {
 	Raw
 }
@@ -51,6 +69,12 @@ type ItemBlock struct {
 type ParagraphBlock struct {
 	Raw
 }
+
+// HTMLBlock is a fragment of the document starting with a block-level HTML
+// tag or a comment, which should be passed verbatim to the output.
+type HTMLBlock struct {
+	Raw
+}
 type ReferenceResolutionBlock struct {
 	ReferenceID string
 	URL, Title  string
@@ -2,6 +2,12 @@ package md
 
 type Region []Run
 
+// Run is a fragment of a single line of the document. Bytes is always a
+// subslice of the line's contents as passed to the block parser, so the
+// column where it starts can be found with mdutils.OffsetIn. Line is -1 when
+// the position is not known (e.g. for spans parsed with mdspan.Parse from a
+// plain buffer).
+//
 // TODO(akavel): rename Run to something prettier?
 type Run struct {
 	Line  int
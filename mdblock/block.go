@@ -3,12 +3,14 @@ package mdblock // import "gopkg.in/akavel/vfmd.v1/mdblock"
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"fmt"
 	"io"
 	"strings"
 
 	"gopkg.in/akavel/vfmd.v1/md"
 	"gopkg.in/akavel/vfmd.v1/mdspan"
+	"gopkg.in/akavel/vfmd.v1/mdutils"
 )
 
 // func unstack() {
@@ -34,6 +36,8 @@ const (
 	TopBlocks
 )
 
+// Context may also implement a GetLimits() Limits method, to restrict the
+// resources used for parsing; see LimitsOf.
 type Context interface {
 	GetMode() Mode
 	GetDetectors() Detectors
@@ -41,18 +45,78 @@ type Context interface {
 	Emit(md.Tag)
 }
 
+// LimitsOf returns the limits of ctx, if it has a GetLimits() Limits method,
+// or no limits otherwise.
+func LimitsOf(ctx Context) Limits {
+	if l, ok := ctx.(interface{ GetLimits() Limits }); ok {
+		return l.GetLimits()
+	}
+	return Limits{}
+}
+
+// Limits restrict resources used by the parser, to protect against malicious
+// input. Zero values mean no limit.
+type Limits struct {
+	// MaxDepth is the maximum nesting level of blocks in blockquotes and
+	// lists. Contents of blocks nested deeper are parsed only as paragraphs
+	// (and blank lines). A negative value means no more nesting is allowed;
+	// it is used for contexts of nested blocks.
+	MaxDepth int
+	// MaxBytes is the maximum size of the (preprocessed) document. If it is
+	// exceeded, parsing fails with a *LimitError.
+	MaxBytes int
+	// Spans restrict parsing of spans in every paragraph, header, etc.
+	Spans mdspan.Limits
+}
+
+// LimitError is returned when the document exceeds Limits.MaxBytes.
+type LimitError struct {
+	// Line is the number of the line on which the limit was exceeded.
+	Line     int
+	MaxBytes int
+}
+
+func (e *LimitError) Error() string {
+	return fmt.Sprintf("vfmd: document exceeds limit of %d bytes at line %d", e.MaxBytes, e.Line)
+}
+
 type defaultContext struct {
 	mode          Mode
 	tags          []md.Tag
 	detectors     Detectors
 	spanDetectors []mdspan.Detector
+	limits        Limits
 }
 
 func (c *defaultContext) GetMode() Mode                       { return c.mode }
 func (c *defaultContext) GetDetectors() Detectors             { return c.detectors }
 func (c *defaultContext) GetSpanDetectors() []mdspan.Detector { return c.spanDetectors }
+func (c *defaultContext) GetLimits() Limits                   { return c.limits }
 func (c *defaultContext) Emit(tag md.Tag)                     { c.tags = append(c.tags, tag) }
 
+// nestedContext returns a context for parsing contents of a blockquote or
+// list item found in ctx. If Limits.MaxDepth is reached, only paragraphs and
+// blank lines are detected in it.
+func nestedContext(ctx Context, inQuote, inList bool) *defaultContext {
+	buf := &defaultContext{
+		mode:          ctx.GetMode(),
+		detectors:     changedParagraphDetector(ctx, inQuote, inList),
+		spanDetectors: ctx.GetSpanDetectors(),
+		limits:        LimitsOf(ctx),
+	}
+	switch depth := buf.limits.MaxDepth; {
+	case depth > 1:
+		buf.limits.MaxDepth--
+	case depth == 1 || depth < 0:
+		buf.limits.MaxDepth = -1
+		buf.detectors = Detectors{
+			DetectorFunc(DetectNull),
+			ParagraphDetector{InQuote: inQuote, InList: inList},
+		}
+	}
+	return buf
+}
+
 type Parser struct {
 	Context
 
@@ -60,6 +124,10 @@ type Parser struct {
 	handler Handler
 }
 
+// GetLimits returns the limits of p.Context, so that they are seen by the
+// handlers, which get p as their context.
+func (p *Parser) GetLimits() Limits { return LimitsOf(p.Context) }
+
 // func (p *Parser) Emit(tag Tag) { unstack(); p.Context.Emit(tag) }
 func (p *Parser) Close() error { return p.WriteLine(Line{}) }
 func (p *Parser) WriteLine(line Line) error {
@@ -93,8 +161,7 @@ func (p *Parser) WriteLine(line Line) error {
 	}
 	p.handler = p.GetDetectors().Find(*p.start, line)
 	if p.handler == nil {
-		// TODO(akavel): return error object with line number and contents
-		return fmt.Errorf("vfmd: no block detector matched line %d: %q", p.start.Line, string(p.start.Bytes))
+		return &ParseError{Line: *p.start, Column: -1}
 	}
 	// fmt.Printf(".:.handle? %d %q\n", p.start.Line, string(p.start.Bytes))
 	consumed, err := p.handler.Handle(*p.start, p)
@@ -103,14 +170,58 @@ func (p *Parser) WriteLine(line Line) error {
 		return err
 	}
 	if !consumed {
-		return fmt.Errorf("vfmd: detector %T failed to handle first line %d: %q", p.handler, p.start.Line, string(p.start.Bytes))
+		return &ParseError{Line: *p.start, Column: -1, Handler: p.handler}
 	}
 	p.start = nil
 	return p.WriteLine(line)
 }
 
+// ParseError is returned by Parser when a line can't be parsed.
+type ParseError struct {
+	// Line is the offending line. In nested blocks (e.g. in blockquotes), its
+	// Bytes are only a part of the line of input.
+	Line Line
+	// Column is the offset in bytes of Line.Bytes in the line of input, or
+	// -1 if not known. It is filled by QuickParse and Parse.
+	Column int
+	// Handler is the handler returned by a detector which failed to handle
+	// the line, or nil if no detector matched it.
+	Handler Handler
+}
+
+func (e *ParseError) Error() string {
+	if e.Handler == nil {
+		return fmt.Sprintf("vfmd: no block detector matched line %d: %q", e.Line.Line, string(e.Line.Bytes))
+	}
+	return fmt.Sprintf("vfmd: detector %T failed to handle first line %d: %q", e.Handler, e.Line.Line, string(e.Line.Bytes))
+}
+
 // Important: r must be pre-processed with vfmd.QuickPrep or vfmd.Preprocessor
 func QuickParse(r io.Reader, mode Mode, detectors Detectors, spanDetectors []mdspan.Detector) ([]md.Tag, error) {
+	var tags []md.Tag
+	err := Parse(r, mode, detectors, spanDetectors, func(block []md.Tag) error {
+		tags = append(tags, block...)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return tags, nil
+}
+
+// Parse works like QuickParse, but instead of returning all tags at the end,
+// it calls emit with the tags of every top-level block (i.e. the block tag,
+// its children, and the closing md.End{}) as soon as the block is closed.
+// This allows processing big documents without keeping all their tags in
+// memory. If emit returns an error, parsing stops and the error is returned.
+//
+// Important: r must be pre-processed with vfmd.QuickPrep or vfmd.Preprocessor
+func Parse(r io.Reader, mode Mode, detectors Detectors, spanDetectors []mdspan.Detector, emit func(block []md.Tag) error) error {
+	return ParseLimits(r, mode, detectors, spanDetectors, Limits{}, emit)
+}
+
+// ParseLimits works like Parse, with resources restricted by limits.
+func ParseLimits(r io.Reader, mode Mode, detectors Detectors, spanDetectors []mdspan.Detector, limits Limits, emit func(block []md.Tag) error) error {
 	scan := bufio.NewScanner(r)
 	scan.Split(splitKeepingEOLs)
 	if detectors == nil {
@@ -119,33 +230,95 @@ func QuickParse(r io.Reader, mode Mode, detectors Detectors, spanDetectors []mds
 	if spanDetectors == nil {
 		spanDetectors = mdspan.DefaultDetectors
 	}
-	context := &defaultContext{
-		mode:          mode,
-		detectors:     detectors,
-		spanDetectors: spanDetectors,
+	context := &streamContext{
+		defaultContext: defaultContext{
+			mode:          mode,
+			detectors:     detectors,
+			spanDetectors: spanDetectors,
+			limits:        limits,
+		},
+		emit: emit,
 	}
 	parser := Parser{
 		Context: context,
 	}
+	// Last two lines, for finding column of a ParseError. Detection of a
+	// block happens only when its second line is read.
+	var recent [2]Line
+	size := 0
 	for i := 0; scan.Scan(); i++ {
+		size += len(scan.Bytes())
+		if limits.MaxBytes > 0 && size > limits.MaxBytes {
+			return &LimitError{Line: i, MaxBytes: limits.MaxBytes}
+		}
 		// fmt.Print(scan.Text())
-		err := parser.WriteLine(Line{
+		line := Line{
 			Line: i,
-			// Copy the line contents so that scan.Scan() doesn't invalidate it
-			Bytes: append([]byte(nil), scan.Bytes()...),
-		})
+			// Copy the line contents so that scan.Scan() doesn't invalidate it.
+			// The exact capacity lets vfmd.SourceMap find offsets of runs.
+			Bytes: append(make([]byte, 0, len(scan.Bytes())), scan.Bytes()...),
+		}
+		recent[0], recent[1] = recent[1], line
+		err := parser.WriteLine(line)
 		if err != nil {
-			return nil, err
+			return withColumn(err, recent[:])
+		}
+		if context.err != nil {
+			return context.err
 		}
 	}
 	if scan.Err() != nil {
-		return nil, scan.Err()
+		return scan.Err()
 	}
 	err := parser.Close()
 	if err != nil {
-		return nil, err
+		return withColumn(err, recent[:])
+	}
+	return context.err
+}
+
+// withColumn fills the Column of err, if it is a ParseError for one of lines.
+func withColumn(err error, lines []Line) error {
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Column >= 0 || cap(perr.Line.Bytes) == 0 {
+		return err
+	}
+	for _, l := range lines {
+		if l.Line != perr.Line.Line || cap(l.Bytes) == 0 {
+			continue
+		}
+		if col, ok := mdutils.OffsetIn(l.Bytes, perr.Line.Bytes); ok {
+			perr.Column = col
+		}
+	}
+	return err
+}
+
+// streamContext passes the tags of every top-level block to emit when the
+// block is closed.
+type streamContext struct {
+	defaultContext
+	// depth of nesting of the last emitted tag
+	depth int
+	emit  func(block []md.Tag) error
+	err   error
+}
+
+func (c *streamContext) Emit(tag md.Tag) {
+	c.tags = append(c.tags, tag)
+	switch tag.(type) {
+	case md.End:
+		c.depth--
+	case md.Prose:
+	default:
+		c.depth++
+	}
+	if c.depth == 0 {
+		if c.err == nil {
+			c.err = c.emit(c.tags)
+		}
+		c.tags = nil
 	}
-	return context.tags, nil
 }
 
 // Line is a Run that may have at most one '\n', as last byte
@@ -215,6 +388,7 @@ var DefaultDetectors = Detectors{
 	DetectorFunc(DetectHorizontalRule),
 	DetectorFunc(DetectUnorderedList),
 	DetectorFunc(DetectOrderedList),
+	DetectorFunc(DetectHTMLBlock),
 	ParagraphDetector{},
 }
 
@@ -264,12 +438,7 @@ func parseSpans(region md.Raw, ctx Context) {
 	if ctx.GetMode() != BlocksAndSpans {
 		return
 	}
-	// FIXME(akavel): parse the spans correctly w.r.t. region Run boundaries and collect proper Run.Line values
-	buf := []byte{}
-	for _, run := range region {
-		buf = append(buf, run.Bytes...) // FIXME(akavel): quick & dirty & foul prototyping hack
-	}
-	spans := mdspan.Parse(buf, ctx.GetSpanDetectors())
+	spans := mdspan.ParseRegionLimits(md.Region(region), ctx.GetSpanDetectors(), LimitsOf(ctx).Spans)
 	for _, span := range spans {
 		ctx.Emit(span)
 	}
@@ -0,0 +1,41 @@
+package mdblock
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestParseError(test *testing.T) {
+	failing := DetectorFunc(func(first, second Line, detectors Detectors) Handler {
+		if !bytes.HasPrefix(first.Bytes, []byte("!")) {
+			return nil
+		}
+		return HandlerFunc(func(Line, Context) (bool, error) { return false, nil })
+	})
+	detectors := Detectors{DetectorFunc(DetectNull), DetectorFunc(DetectQuote), failing}
+	cases := []struct {
+		input   string
+		line    int
+		column  int
+		bytes   string
+		handler bool
+	}{
+		{"\n> a\n> b\n", 1, 2, "a\n", false},
+		{"\n\n!x\n", 2, 0, "!x\n", true},
+		{"> !x\n", 0, 2, "!x\n", true},
+	}
+	for _, c := range cases {
+		_, err := QuickParse(bytes.NewReader([]byte(c.input)), BlocksAndSpans, detectors, nil)
+		var perr *ParseError
+		if !errors.As(err, &perr) {
+			test.Errorf("case %q expected ParseError, got %v", c.input, err)
+			continue
+		}
+		if perr.Line.Line != c.line || perr.Column != c.column || string(perr.Line.Bytes) != c.bytes || (perr.Handler != nil) != c.handler {
+			test.Errorf("case %q expected line %d column %d %q handler %v, got %d %d %q %T",
+				c.input, c.line, c.column, c.bytes, c.handler,
+				perr.Line.Line, perr.Column, perr.Line.Bytes, perr.Handler)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package mdblock
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+)
+
+func TestParseStreaming(test *testing.T) {
+	const input = "# a\n\npara\ngraph\n\n> quote\n> * item\n\n    code\n"
+	expected, err := QuickParse(bytes.NewReader([]byte(input)), BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	var blocks [][]md.Tag
+	var tags []md.Tag
+	err = Parse(bytes.NewReader([]byte(input)), BlocksAndSpans, nil, nil, func(block []md.Tag) error {
+		blocks = append(blocks, block)
+		tags = append(tags, block...)
+		return nil
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	// Every top-level block must be passed separately.
+	n, depth := 0, 0
+	for _, t := range expected {
+		switch t.(type) {
+		case md.End:
+			depth--
+		case md.Prose:
+		default:
+			if depth == 0 {
+				n++
+			}
+			depth++
+		}
+	}
+	if len(blocks) != n {
+		test.Errorf("expected %d blocks, got %d:\n%s", n, len(blocks), spew.Sdump(blocks))
+	}
+	if !bytes.Equal([]byte(spew.Sdump(tags)), []byte(spew.Sdump(expected))) {
+		test.Errorf("streamed tags differ from QuickParse:\n%s",
+			diff.Diff(spew.Sdump(expected), spew.Sdump(tags)))
+	}
+}
+
+func TestParseStreamingIncremental(test *testing.T) {
+	r, w := io.Pipe()
+	blocks := make(chan []md.Tag)
+	done := make(chan error, 1)
+	go func() {
+		done <- Parse(r, BlocksAndSpans, nil, nil, func(block []md.Tag) error {
+			blocks <- block
+			return nil
+		})
+		close(blocks)
+	}()
+	// The header is closed by the first line of the paragraph, before the
+	// rest of the input is written.
+	io.WriteString(w, "# header\n")
+	io.WriteString(w, "para\n")
+	if _, ok := (<-blocks)[0].(md.AtxHeaderBlock); !ok {
+		test.Errorf("expected header block first")
+	}
+	io.WriteString(w, "graph\n")
+	w.Close()
+	if _, ok := (<-blocks)[0].(md.ParagraphBlock); !ok {
+		test.Errorf("expected paragraph block second")
+	}
+	if b, ok := <-blocks; ok {
+		test.Errorf("unexpected block: %s", spew.Sdump(b))
+	}
+	if err := <-done; err != nil {
+		test.Error(err)
+	}
+}
+
+func TestParseStreamingError(test *testing.T) {
+	stop := errors.New("stop")
+	n := 0
+	err := Parse(bytes.NewReader([]byte("a\n\nb\n\nc\n")), BlocksAndSpans, nil, nil, func(block []md.Tag) error {
+		n++
+		return stop
+	})
+	if err != stop || n != 1 {
+		test.Errorf("expected stop error after 1 block, got %v after %d", err, n)
+	}
+}
@@ -0,0 +1,99 @@
+package mdblock
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+)
+
+var reHTMLBlockStart = regexp.MustCompile(`^ {0,3}<(/?)([A-Za-z][A-Za-z0-9\-]*)(\s|/?>|$)`)
+
+// htmlBlockTags lists names of the HTML elements which start an HTML block
+// when found at the beginning of a line.
+var htmlBlockTags = map[string]bool{}
+
+func init() {
+	for _, tag := range strings.Fields(`address article aside blockquote
+		body caption center col colgroup dd details dialog dir div dl dt
+		fieldset figcaption figure footer form frame frameset h1 h2 h3 h4 h5
+		h6 head header hr html iframe legend li link main menu menuitem nav
+		noframes ol optgroup option p param pre script section source style
+		summary table tbody td textarea tfoot th thead title tr track ul`) {
+		htmlBlockTags[tag] = true
+	}
+}
+
+// htmlBlockEnd returns the string which, when found, terminates an HTML block
+// starting with the specified line. If it returns "", the block ends before
+// the first blank line. If ok is false, the line doesn't start an HTML block.
+func htmlBlockEnd(line []byte) (end string, ok bool) {
+	trimmed := bytes.TrimLeft(line, " ")
+	if len(line)-len(trimmed) <= 3 && bytes.HasPrefix(trimmed, []byte("<!--")) {
+		return "-->", true
+	}
+	m := reHTMLBlockStart.FindSubmatch(bytes.TrimRight(line, "\n"))
+	if m == nil {
+		return "", false
+	}
+	name := strings.ToLower(string(m[2]))
+	if !htmlBlockTags[name] {
+		return "", false
+	}
+	switch name {
+	case "pre", "script", "style", "textarea":
+		if len(m[1]) == 0 {
+			return "</" + name + ">", true
+		}
+	}
+	return "", true
+}
+
+func DetectHTMLBlock(first, second Line, detectors Detectors) Handler {
+	end, ok := htmlBlockEnd(first.Bytes)
+	if !ok {
+		return nil
+	}
+	block := md.HTMLBlock{}
+	done := false
+	return HandlerFunc(func(next Line, ctx Context) (bool, error) {
+		if next.EOF() {
+			return htmlBlockClose(block, ctx)
+		}
+		if done || (end == "" && next.isBlank()) {
+			return htmlBlockClose(block, ctx)
+		}
+		block.Raw = append(block.Raw, md.Run(next))
+		if end != "" && bytes.Contains(bytes.ToLower(next.Bytes), []byte(end)) {
+			done = true
+		}
+		return true, nil
+	})
+}
+
+// htmlCommentOpen reports if an HTML comment is left unclosed at the end of
+// line, given whether one was unclosed at its beginning.
+func htmlCommentOpen(open bool, line []byte) bool {
+	for {
+		if open {
+			i := bytes.Index(line, []byte("-->"))
+			if i == -1 {
+				return true
+			}
+			line, open = line[i+3:], false
+			continue
+		}
+		i := bytes.Index(line, []byte("<!--"))
+		if i == -1 {
+			return false
+		}
+		line, open = line[i+4:], true
+	}
+}
+
+func htmlBlockClose(block md.HTMLBlock, ctx Context) (bool, error) {
+	ctx.Emit(block)
+	ctx.Emit(md.End{})
+	return false, nil
+}
@@ -0,0 +1,86 @@
+package mdblock
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdspan"
+)
+
+func parseLimits(input string, limits Limits) ([]md.Tag, error) {
+	var tags []md.Tag
+	err := ParseLimits(bytes.NewReader([]byte(input)), BlocksAndSpans, nil, nil, limits, func(block []md.Tag) error {
+		tags = append(tags, block...)
+		return nil
+	})
+	return tags, err
+}
+
+func TestLimits(test *testing.T) {
+	cases := []struct {
+		input  string
+		limits Limits
+		// numbers of tags of each type, by their %T
+		counts map[string]int
+	}{
+		{"> > > > a\n", Limits{}, map[string]int{"md.QuoteBlock": 4, "md.ParagraphBlock": 1}},
+		{"> > > > a\n", Limits{MaxDepth: 2}, map[string]int{"md.QuoteBlock": 2, "md.ParagraphBlock": 1}},
+		{"> * > 1. a\n", Limits{MaxDepth: 3}, map[string]int{"md.QuoteBlock": 2, "md.UnorderedListBlock": 1, "md.OrderedListBlock": 0}},
+		{"* a\n\n  > b\n", Limits{MaxDepth: 1}, map[string]int{"md.UnorderedListBlock": 1, "md.QuoteBlock": 0, "md.ParagraphBlock": 2}},
+		{"*a* *b*\n\n> *c* *d*\n", Limits{Spans: mdspan.Limits{MaxSpans: 2}}, map[string]int{"md.Emphasis": 2}},
+	}
+	for _, c := range cases {
+		tags, err := parseLimits(c.input, c.limits)
+		if err != nil {
+			test.Errorf("case %q %+v: %s", c.input, c.limits, err)
+			continue
+		}
+		counts := map[string]int{}
+		for _, t := range tags {
+			counts[fmt.Sprintf("%T", t)]++
+		}
+		for typ, n := range c.counts {
+			if counts[typ] != n {
+				test.Errorf("case %q %+v expected %d %s, got %d:\n%s",
+					c.input, c.limits, n, typ, counts[typ], spew.Sdump(tags))
+			}
+		}
+	}
+}
+
+func TestLimitsMaxBytes(test *testing.T) {
+	input := strings.Repeat("line\n", 10)
+	_, err := parseLimits(input, Limits{MaxBytes: len(input)})
+	if err != nil {
+		test.Errorf("unexpected error: %s", err)
+	}
+	_, err = parseLimits(input, Limits{MaxBytes: 22})
+	var lerr *LimitError
+	if !errors.As(err, &lerr) || lerr.Line != 4 {
+		test.Errorf("expected LimitError at line 4, got %v", err)
+	}
+}
+
+// plainContext implements Context without GetLimits.
+type plainContext struct{}
+
+func (plainContext) GetMode() Mode                       { return BlocksAndSpans }
+func (plainContext) GetDetectors() Detectors             { return DefaultDetectors }
+func (plainContext) GetSpanDetectors() []mdspan.Detector { return mdspan.DefaultDetectors }
+func (plainContext) Emit(md.Tag)                         {}
+
+func TestLimitsOf(test *testing.T) {
+	limits := Limits{MaxDepth: 3}
+	if l := LimitsOf(&defaultContext{limits: limits}); l != limits {
+		test.Errorf("expected %+v, got %+v", limits, l)
+	}
+	if l := LimitsOf(plainContext{}); l != (Limits{}) {
+		test.Errorf("expected no limits, got %+v", l)
+	}
+}
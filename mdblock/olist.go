@@ -30,11 +30,7 @@ func DetectOrderedList(start, second Line, detectors Detectors) Handler {
 		prev := carry
 		carry = &next
 		if prev == nil {
-			buf = &defaultContext{
-				mode:          ctx.GetMode(),
-				detectors:     changedParagraphDetector(ctx, false, true),
-				spanDetectors: ctx.GetSpanDetectors(),
-			}
+			buf = nestedContext(ctx, false, true)
 			block.Raw = append(block.Raw, md.Run(next))
 			buf.Emit(block)
 			if ctx.GetMode() != TopBlocks {
@@ -14,17 +14,19 @@ type ParagraphDetector struct {
 
 func (p ParagraphDetector) Detect(first, second Line, detectors Detectors) Handler {
 	block := md.ParagraphBlock{}
+	inComment := false
 	return HandlerFunc(func(next Line, ctx Context) (bool, error) {
 		if next.EOF() {
 			return p.close(block, ctx)
 		}
 		if len(block.Raw) == 0 {
 			block.Raw = append(block.Raw, md.Run(next))
+			inComment = htmlCommentOpen(inComment, next.Bytes)
 			return true, nil
 		}
 		prev := Line(block.Raw[len(block.Raw)-1])
-		// TODO(akavel): support HTML parser & related interactions [#paragraph-line-sequence]
-		if prev.isBlank() {
+		// Blank lines don't break an HTML comment. [#paragraph-line-sequence]
+		if prev.isBlank() && !inComment {
 			return p.close(block, ctx)
 		}
 		nextBytes := bytes.TrimRight(next.Bytes, "\n")
@@ -35,8 +37,12 @@ func (p ParagraphDetector) Detect(first, second Line, detectors Detectors) Handl
 				(p.InList && reUnorderedList.Match(nextBytes)) {
 				return p.close(block, ctx)
 			}
+			if _, ok := htmlBlockEnd(next.Bytes); ok && !inComment {
+				return p.close(block, ctx)
+			}
 		}
 		block.Raw = append(block.Raw, md.Run(next))
+		inComment = htmlCommentOpen(inComment, next.Bytes)
 		return true, nil
 	})
 }
@@ -34,11 +34,7 @@ func DetectQuote(first, second Line, detectors Detectors) Handler {
 		carry = &next
 		// First line?
 		if prev == nil {
-			buf = &defaultContext{
-				mode:          ctx.GetMode(),
-				detectors:     changedParagraphDetector(ctx, true, false),
-				spanDetectors: ctx.GetSpanDetectors(),
-			}
+			buf = nestedContext(ctx, true, false)
 			block.Raw = append(block.Raw, md.Run(next))
 			if ctx.GetMode() != TopBlocks {
 				parser = &Parser{
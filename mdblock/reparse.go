@@ -0,0 +1,217 @@
+package mdblock
+
+import (
+	"fmt"
+	"reflect"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdspan"
+)
+
+// Edit describes a change of a document: lines From to To (exclusive) of the
+// old document were replaced with N new lines.
+type Edit struct {
+	From, To int
+	N        int
+}
+
+// Change describes the part of the tag stream updated by Reparse: tags
+// old[OldStart:OldEnd] were replaced with new[NewStart:NewEnd], which were
+// parsed from lines FromLine to ToLine (exclusive) of the new document. Line
+// numbers in tags after the change are shifted if the number of lines changed.
+type Change struct {
+	OldStart, OldEnd int
+	NewStart, NewEnd int
+	FromLine, ToLine int
+}
+
+// Lines splits preprocessed data into lines like QuickParse does, including
+// the LF characters. The lines are subslices of prep, with capacity limited to
+// their length, as required by vfmd.SourceMap.RunPosition.
+func Lines(prep []byte) [][]byte {
+	var lines [][]byte
+	for len(prep) > 0 {
+		n, _, _ := splitKeepingEOLs(prep, true)
+		lines = append(lines, prep[:n:n])
+		prep = prep[n:]
+	}
+	return lines
+}
+
+// Reparse updates old, which must be the result of parsing a document with
+// the same mode and detectors, after the document was modified by edit. The
+// lines contain the whole new document, as split by Lines. Only the
+// top-level blocks from the one preceding the edit are parsed again, until
+// the parser reaches a line where an old top-level block after the edit
+// started; the remaining old tags are reused.
+func Reparse(old []md.Tag, lines [][]byte, edit Edit, mode Mode, detectors Detectors, spanDetectors []mdspan.Detector) ([]md.Tag, Change, error) {
+	return ReparseLimits(old, lines, edit, mode, detectors, spanDetectors, Limits{})
+}
+
+// ReparseLimits works like Reparse, with resources restricted by limits,
+// which should be the same as used for parsing old. Limits.MaxBytes applies
+// to the whole new document.
+func ReparseLimits(old []md.Tag, lines [][]byte, edit Edit, mode Mode, detectors Detectors, spanDetectors []mdspan.Detector, limits Limits) ([]md.Tag, Change, error) {
+	if edit.From < 0 || edit.To < edit.From || edit.N < 0 || edit.From+edit.N > len(lines) {
+		return nil, Change{}, fmt.Errorf("vfmd: invalid edit of lines %d-%d into %d lines, in document of %d lines",
+			edit.From, edit.To, edit.N, len(lines))
+	}
+	if limits.MaxBytes > 0 {
+		size := 0
+		for i, l := range lines {
+			size += len(l)
+			if size > limits.MaxBytes {
+				return nil, Change{}, &LimitError{Line: i, MaxBytes: limits.MaxBytes}
+			}
+		}
+	}
+	if detectors == nil {
+		detectors = DefaultDetectors
+	}
+	if spanDetectors == nil {
+		spanDetectors = mdspan.DefaultDetectors
+	}
+	delta := edit.N - (edit.To - edit.From)
+	blocks := topBlocks(old)
+
+	// A top-level block is closed by the first line of the next one, so
+	// blocks which started before the last line preceding the edit are not
+	// affected by it.
+	change := Change{}
+	for _, b := range blocks {
+		if b.line < 0 {
+			continue
+		}
+		if b.line >= edit.From {
+			break
+		}
+		change.OldStart, change.FromLine = b.tag, b.line
+	}
+	change.NewStart = change.OldStart
+
+	var tags []md.Tag
+	tags = append(tags, old[:change.OldStart]...)
+	context := &streamContext{
+		defaultContext: defaultContext{
+			mode:          mode,
+			detectors:     detectors,
+			spanDetectors: spanDetectors,
+			limits:        limits,
+		},
+		emit: func(block []md.Tag) error {
+			tags = append(tags, block...)
+			return nil
+		},
+	}
+	parser := Parser{
+		Context: context,
+	}
+	next := 0 // first old block which may be a resynchronization point
+	for i := change.FromLine; i < len(lines); i++ {
+		err := parser.WriteLine(Line{Line: i, Bytes: lines[i]})
+		if err != nil {
+			return nil, Change{}, withColumn(err, []Line{{i - 1, lineAt(lines, i-1)}, {i, lines[i]}})
+		}
+		// If a new top-level block starts on a line after the edit, where
+		// an old block started too, the rest of the document would be
+		// parsed exactly as before.
+		if parser.handler != nil || parser.start == nil || parser.start.Line != i || i < edit.From+edit.N {
+			continue
+		}
+		for next < len(blocks) && (blocks[next].line < 0 || blocks[next].line+delta < i) {
+			next++
+		}
+		if next < len(blocks) && blocks[next].line+delta == i {
+			change.OldEnd, change.NewEnd, change.ToLine = blocks[next].tag, len(tags), i
+			for _, t := range old[blocks[next].tag:] {
+				tags = append(tags, shiftLines(t, delta))
+			}
+			return tags, change, nil
+		}
+	}
+	err := parser.Close()
+	if err != nil {
+		return nil, Change{}, withColumn(err, []Line{{len(lines) - 1, lineAt(lines, len(lines)-1)}})
+	}
+	change.OldEnd, change.NewEnd, change.ToLine = len(old), len(tags), len(lines)
+	return tags, change, nil
+}
+
+func lineAt(lines [][]byte, i int) []byte {
+	if i < 0 || i >= len(lines) {
+		return nil
+	}
+	return lines[i]
+}
+
+type topBlock struct {
+	tag  int // index in tags
+	line int // first line, or -1 if not known
+}
+
+// topBlocks finds the top-level blocks in tags.
+func topBlocks(tags []md.Tag) []topBlock {
+	var blocks []topBlock
+	depth := 0
+	for i, t := range tags {
+		switch t.(type) {
+		case md.End:
+			depth--
+			continue
+		case md.Prose:
+			continue
+		}
+		if depth == 0 {
+			b := topBlock{tag: i, line: -1}
+			if r, ok := t.(interface{ GetRaw() md.Region }); ok {
+				if raw := r.GetRaw(); len(raw) > 0 {
+					b.line = raw[0].Line
+				}
+			}
+			blocks = append(blocks, b)
+		}
+		depth++
+	}
+	return blocks
+}
+
+var runType = reflect.TypeOf(md.Run{})
+
+// shiftLines returns a copy of t, with delta added to the Line of every
+// md.Run found in it.
+func shiftLines(t md.Tag, delta int) md.Tag {
+	if delta == 0 || t == nil {
+		return t
+	}
+	v := reflect.ValueOf(t)
+	c := reflect.New(v.Type()).Elem()
+	c.Set(v)
+	shiftValue(c, delta)
+	return c.Interface()
+}
+
+func shiftValue(v reflect.Value, delta int) {
+	switch {
+	case v.Type() == runType:
+		line := v.FieldByName("Line")
+		if line.Int() >= 0 {
+			line.SetInt(line.Int() + int64(delta))
+		}
+	case v.Kind() == reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			if f := v.Field(i); f.CanSet() {
+				shiftValue(f, delta)
+			}
+		}
+	case v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Struct:
+		if v.IsNil() {
+			return
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		reflect.Copy(c, v)
+		for i := 0; i < c.Len(); i++ {
+			shiftValue(c.Index(i), delta)
+		}
+		v.Set(c)
+	}
+}
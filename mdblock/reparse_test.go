@@ -0,0 +1,139 @@
+package mdblock
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdspan"
+)
+
+func TestReparse(test *testing.T) {
+	doc := strings.Join([]string{
+		"# header", // 0
+		"",
+		"para",
+		"graph",
+		"",
+		"> quote", // 5
+		"> * item",
+		"",
+		"* one",
+		"* two",
+		"", // 10
+		"    code",
+		"",
+		"last para",
+		"",
+	}, "\n")
+	cases := []struct {
+		edit   Edit
+		lines  string
+		reused bool // whether the tags after the change are reused
+	}{
+		{Edit{2, 3, 1}, "Para\n", true},
+		{Edit{3, 4, 1}, "===\n", true},
+		{Edit{4, 5, 1}, "more\n", true},
+		{Edit{6, 7, 2}, "> * item\n> * another\n", true},
+		{Edit{1, 1, 2}, "\nnew\n", true},
+		{Edit{2, 4, 0}, "", true},
+		{Edit{8, 9, 1}, "```\n", true},
+		{Edit{11, 12, 1}, "    code2\n", true},
+		{Edit{13, 14, 1}, "changed\n", false},
+		{Edit{14, 14, 2}, "\nappended\n", false},
+		{Edit{0, 14, 0}, "", false},
+		{Edit{0, 0, 1}, "> x\n", true},
+	}
+	// Capacities of slices differ in reused tags with shifted lines.
+	dump := spew.ConfigState{Indent: " ", DisableCapacities: true}
+	for _, c := range cases {
+		oldLines := Lines([]byte(doc))
+		old, err := QuickParse(bytes.NewReader([]byte(doc)), BlocksAndSpans, nil, nil)
+		if err != nil {
+			test.Fatal(err)
+		}
+		var buf []byte
+		for _, l := range oldLines[:c.edit.From] {
+			buf = append(buf, l...)
+		}
+		buf = append(buf, c.lines...)
+		for _, l := range oldLines[c.edit.To:] {
+			buf = append(buf, l...)
+		}
+		expected, err := QuickParse(bytes.NewReader(buf), BlocksAndSpans, nil, nil)
+		if err != nil {
+			test.Fatal(err)
+		}
+
+		// Reuse the unchanged lines, so that the old tags point to the
+		// same data.
+		lines := append([][]byte{}, oldLines[:c.edit.From]...)
+		lines = append(lines, Lines([]byte(c.lines))...)
+		lines = append(lines, oldLines[c.edit.To:]...)
+		tags, change, err := Reparse(old, lines, c.edit, BlocksAndSpans, nil, nil)
+		if err != nil {
+			test.Errorf("case %v: %s", c.edit, err)
+			continue
+		}
+		if dump.Sdump(tags) != dump.Sdump(expected) {
+			test.Errorf("case %v %q: expected vs. got DIFF:\n%s",
+				c.edit, c.lines, diff.Diff(dump.Sdump(expected), dump.Sdump(tags)))
+		}
+		if reused := change.OldEnd < len(old); reused != c.reused {
+			test.Errorf("case %v %q: expected reuse %v, got change %+v", c.edit, c.lines, c.reused, change)
+		}
+		if c.edit.From > 4 && change.OldStart == 0 {
+			test.Errorf("case %v %q: reparsed from the beginning", c.edit, c.lines)
+		}
+		if len(old)-change.OldEnd != len(tags)-change.NewEnd {
+			test.Errorf("case %v %q: inconsistent change %+v", c.edit, c.lines, change)
+		}
+	}
+}
+
+func TestReparseShiftLines(test *testing.T) {
+	tag := md.Link{RawEnd: md.Raw{{Line: 3}, {Line: -1}}}
+	shifted := shiftLines(tag, 2).(md.Link)
+	if shifted.RawEnd[0].Line != 5 || shifted.RawEnd[1].Line != -1 {
+		test.Errorf("unexpected result: %v", shifted)
+	}
+	if tag.RawEnd[0].Line != 3 {
+		test.Errorf("original tag modified: %v", tag)
+	}
+}
+
+func TestReparseLimits(test *testing.T) {
+	doc := "para\n\n> a\n\nlast\n"
+	limits := Limits{MaxDepth: 1, Spans: mdspan.Limits{MaxSpans: 1}}
+	old, err := parseLimits(doc, limits)
+	if err != nil {
+		test.Fatal(err)
+	}
+	oldLines := Lines([]byte(doc))
+	lines := append([][]byte{}, oldLines[:2]...)
+	lines = append(lines, Lines([]byte("> > *b* *c*\n"))...)
+	lines = append(lines, oldLines[3:]...)
+	expected, err := parseLimits("para\n\n> > *b* *c*\n\nlast\n", limits)
+	if err != nil {
+		test.Fatal(err)
+	}
+	tags, _, err := ReparseLimits(old, lines, Edit{2, 3, 1}, BlocksAndSpans, nil, nil, limits)
+	if err != nil {
+		test.Fatal(err)
+	}
+	dump := spew.ConfigState{Indent: " ", DisableCapacities: true}
+	if dump.Sdump(tags) != dump.Sdump(expected) {
+		test.Errorf("expected vs. got DIFF:\n%s", diff.Diff(dump.Sdump(expected), dump.Sdump(tags)))
+	}
+
+	_, _, err = ReparseLimits(old, lines, Edit{2, 3, 1}, BlocksAndSpans, nil, nil, Limits{MaxBytes: len(doc)})
+	var lerr *LimitError
+	if !errors.As(err, &lerr) || lerr.Line != 2 {
+		test.Errorf("expected LimitError at line 2, got %v", err)
+	}
+}
@@ -29,11 +29,7 @@ func DetectUnorderedList(start, second Line, detectors Detectors) Handler {
 		carry = &next
 		// First line? Init stuff and accept unconditionally, already tested.
 		if prev == nil {
-			buf = &defaultContext{
-				mode:          ctx.GetMode(),
-				detectors:     changedParagraphDetector(ctx, false, true),
-				spanDetectors: ctx.GetSpanDetectors(),
-			}
+			buf = nestedContext(ctx, false, true)
 			block.Raw = append(block.Raw, md.Run(next))
 			buf.Emit(block)
 			if ctx.GetMode() != TopBlocks {
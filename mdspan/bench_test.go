@@ -0,0 +1,54 @@
+package mdspan
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+)
+
+// pathological inputs, which used to take quadratic time
+var benchInputs = []struct {
+	name string
+	unit string
+}{
+	{"LinkParen", "](x "},
+	{"ImageParen", "![a](x "},
+	{"LinkRef", "[a] [b "},
+	{"Brackets", "[a]("},
+	{"Images", "![a]"},
+	{"Links", "[a](b) "},
+	{"Spaces", " "},
+	{"HTMLComments", "<!-- "},
+}
+
+func BenchmarkParse(b *testing.B) {
+	for _, in := range benchInputs {
+		for _, n := range []int{1000, 10000, 100000} {
+			buf := bytes.Repeat([]byte(in.unit), n)
+			b.Run(fmt.Sprintf("%s/%d", in.name, n), func(b *testing.B) {
+				b.SetBytes(int64(len(buf)))
+				for i := 0; i < b.N; i++ {
+					Parse(buf, nil)
+				}
+			})
+		}
+	}
+}
+
+// BenchmarkParseRegion parses a paragraph of many short lines.
+func BenchmarkParseRegion(b *testing.B) {
+	for _, n := range []int{1000, 10000, 100000} {
+		region := md.Region{}
+		for i := 0; i < n; i++ {
+			region = append(region, md.Run{Line: i, Bytes: []byte("a *b* [c](d)\n")})
+		}
+		b.Run(fmt.Sprint(n), func(b *testing.B) {
+			b.SetBytes(int64(n * len("a *b* [c](d)\n")))
+			for i := 0; i < b.N; i++ {
+				ParseRegion(region, nil)
+			}
+		})
+	}
+}
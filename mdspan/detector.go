@@ -2,7 +2,9 @@ package mdspan // import "gopkg.in/akavel/vfmd.v1/mdspan"
 
 import (
 	"bytes"
+	"html"
 	"regexp"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -26,7 +28,9 @@ var DefaultDetectors = []Detector{
 	DetectorFunc(DetectCode),
 	DetectorFunc(DetectImage),
 	DetectorFunc(DetectAutomaticLink),
-	// TODO(akavel): DetectHTML,
+	DetectorFunc(DetectHTML),
+	DetectorFunc(DetectEntity),
+	DetectorFunc(DetectHardBreak),
 }
 
 func DetectEscapedChar(s *Context) (consumed int) {
@@ -38,6 +42,35 @@ func DetectEscapedChar(s *Context) (consumed int) {
 	}
 }
 
+// DetectHardBreak detects a line ending with two or more spaces, which is
+// emitted as md.HardBreak.
+func DetectHardBreak(s *Context) (consumed int) {
+	rest := s.Buf[s.Pos:]
+	i := 0
+	for i < len(rest) && rest[i] == ' ' {
+		i++
+	}
+	if i < 2 || i == len(rest) || rest[i] != '\n' {
+		// Skip the whole run of spaces as text, so that it isn't scanned
+		// again from each of its positions.
+		return i
+	}
+	s.Emit(rest[:i+1], md.HardBreak{}, true)
+	return i + 1
+}
+
+// DetectBackslashHardBreak detects a line ending with a backslash, which is
+// emitted as md.HardBreak. It is not enabled by default; to be effective, it
+// must be put before DetectEscapedChar in the list of detectors.
+func DetectBackslashHardBreak(s *Context) (consumed int) {
+	rest := s.Buf[s.Pos:]
+	if !bytes.HasPrefix(rest, []byte("\\\n")) {
+		return 0
+	}
+	s.Emit(rest[:2], md.HardBreak{}, true)
+	return 2
+}
+
 func DetectLink(s *Context) (consumed int) {
 	// [#procedure-for-identifying-link-tags]
 	c := s.Buf[s.Pos]
@@ -56,13 +89,45 @@ func DetectLink(s *Context) (consumed int) {
 	return closingLinkTag(s)
 }
 
+// findSubmatch works like re.FindSubmatch(b), but the returned subslices
+// keep the capacity of b, so that their positions can be found with
+// mdutils.OffsetIn.
+func findSubmatch(re *regexp.Regexp, b []byte) [][]byte {
+	return submatches(b, re.FindSubmatchIndex(b))
+}
+
+// findSubmatchWithRest works like findSubmatch, but the last submatch is
+// extended to the end of b. This allows regexps to match only a short prefix
+// of b, instead of the whole rest of the buffer with `[\s\S]*$`, which would
+// make parsing quadratic.
+func findSubmatchWithRest(re *regexp.Regexp, b []byte) [][]byte {
+	m := re.FindSubmatchIndex(b)
+	if m != nil {
+		m[len(m)-1] = len(b)
+	}
+	return submatches(b, m)
+}
+
+func submatches(b []byte, m []int) [][]byte {
+	if m == nil {
+		return nil
+	}
+	sub := make([][]byte, len(m)/2)
+	for i := range sub {
+		if m[2*i] >= 0 {
+			sub[i] = b[m[2*i]:m[2*i+1]]
+		}
+	}
+	return sub
+}
+
 var (
 	// e.g.: "] [ref id]"
 	reClosingTagRef = regexp.MustCompile(`^\]\s*\[(([^\\\[\]\` + "`" + `]|\\.)+)\]`)
 	// e.g.: "] (http://www.example.net"...
-	reClosingTagWithoutAngle = regexp.MustCompile(`^\]\s*\(\s*([^\(\)<>\` + "`" + `\s]+)([\)\s][\s\S]*)$`)
+	reClosingTagWithoutAngle = regexp.MustCompile(`^\]\s*\(\s*([^\(\)<>\` + "`" + `\s]+)([\)\s])`)
 	// e.g.: "] ( <http://example.net/?q=)>"...
-	reClosingTagWithAngle = regexp.MustCompile(`^\]\s*\(\s*<([^<>\` + "`" + `]*)>([\)\s][\s\S]*)$`)
+	reClosingTagWithAngle = regexp.MustCompile(`^\]\s*\(\s*<([^<>\` + "`" + `]*)>([\)\s])`)
 
 	reJustClosingParen     = regexp.MustCompile(`^\s*\)`)
 	reTitleAndClosingParen = regexp.MustCompile(`^\s*("(([^\\"\` + "`" + `]|\\.)*)"|'(([^\\'\` + "`" + `]|\\.)*)')\s*\)`)
@@ -78,19 +143,17 @@ func closingLinkTag(s *Context) (consumed int) {
 	rest := s.Buf[s.Pos:]
 
 	// e.g.: "] [ref id]" ?
-	m := reClosingTagRef.FindSubmatch(rest)
+	m := findSubmatch(reClosingTagRef, rest)
 	if m != nil {
 		// cancel all unclosed spans inside the link
-		for s.Openings.Peek().Tag != "[" {
-			s.Openings.Pop()
+		opening := topmostLink(s)
+		if opening == nil {
+			return 1
 		}
 		// emit a link
-		opening := s.Openings.Peek()
 		s.Emit(s.Buf[opening.Pos:][:len(opening.Tag)], md.Link{
 			ReferenceID: mdutils.Simplify(m[1]),
-			RawEnd: md.Raw{
-				md.Run{-1, m[0]},
-			},
+			RawEnd:      s.Raw(m[0]),
 		}, false)
 		s.Emit(m[0], md.End{}, false)
 		s.Openings.Pop()
@@ -100,18 +163,18 @@ func closingLinkTag(s *Context) (consumed int) {
 	}
 
 	// e.g.: "] (http://www.example.net"... ?
-	m = reClosingTagWithoutAngle.FindSubmatch(rest)
+	m = findSubmatchWithRest(reClosingTagWithoutAngle, rest)
 	if m == nil {
 		// e.g.: "] ( <http://example.net/?q=)>"... ?
-		m = reClosingTagWithAngle.FindSubmatch(rest)
+		m = findSubmatchWithRest(reClosingTagWithAngle, rest)
 	}
 	if m != nil {
 		linkURL := mdutils.DelWhites(string(m[1]))
 		residual := m[2]
 		title := ""
-		t := reJustClosingParen.FindSubmatch(residual)
+		t := findSubmatch(reJustClosingParen, residual)
 		if t == nil {
-			t = reTitleAndClosingParen.FindSubmatch(residual)
+			t = findSubmatch(reTitleAndClosingParen, residual)
 		}
 		if t != nil {
 			if len(t) > 1 {
@@ -120,18 +183,16 @@ func closingLinkTag(s *Context) (consumed int) {
 				title = strings.Replace(string(unquoted), "\u000a", "", -1)
 			}
 			// cancel all unclosed spans inside the link
-			for s.Openings.Peek().Tag != "[" {
-				s.Openings.Pop()
+			opening := topmostLink(s)
+			if opening == nil {
+				return 1
 			}
 			// emit a link
-			opening := s.Openings.Peek()
 			closing := rest[:len(rest)-len(residual)+len(t[0])]
 			s.Emit(s.Buf[opening.Pos:][:len(opening.Tag)], md.Link{
-				URL:   linkURL,
-				Title: mdutils.DeEscape(title),
-				RawEnd: md.Raw{
-					md.Run{-1, closing},
-				},
+				URL:    linkURL,
+				Title:  mdutils.DeEscape(title),
+				RawEnd: s.Raw(closing),
 			}, false)
 			s.Emit(closing, md.End{}, false)
 			s.Openings.Pop()
@@ -142,22 +203,20 @@ func closingLinkTag(s *Context) (consumed int) {
 	}
 
 	// e.g.: "] []" ?
-	m = reEmptyRef.FindSubmatch(rest)
+	m = findSubmatch(reEmptyRef, rest)
 	if m == nil {
 		// just: "]"
 		m = [][]byte{rest[:1]}
 	}
 	// cancel all unclosed spans inside the link
-	for s.Openings.Peek().Tag != "[" {
-		s.Openings.Pop()
+	begin := topmostLink(s)
+	if begin == nil {
+		return 1
 	}
 	// emit a link
-	begin := s.Openings.Peek()
 	s.Emit(s.Buf[begin.Pos:][:len(begin.Tag)], md.Link{
 		ReferenceID: mdutils.Simplify(s.Buf[begin.Pos+len(begin.Tag) : s.Pos]),
-		RawEnd: md.Raw{
-			md.Run{-1, m[0]},
-		},
+		RawEnd:      s.Raw(m[0]),
 	}, false)
 	s.Emit(m[0], md.End{}, false)
 	s.Openings.Pop()
@@ -166,6 +225,18 @@ func closingLinkTag(s *Context) (consumed int) {
 	return len(m[0])
 }
 
+// topmostLink pops all openings above the topmost "[", and returns it, or nil
+// if there's none.
+func topmostLink(s *Context) *MaybeOpening {
+	for top := s.Openings.Peek(); top != nil; top = s.Openings.Peek() {
+		if top.Tag == "[" {
+			return top
+		}
+		s.Openings.Pop()
+	}
+	return nil
+}
+
 func DetectEmphasis(s *Context) (consumed int) {
 	rest := s.Buf[s.Pos:]
 	if !isEmph(rest[0]) {
@@ -290,7 +361,7 @@ func DetectCode(s *Context) (consumed int) {
 			// found closing tag!
 			code := rest[len(opening) : i-len(opening)]
 			code = bytes.Trim(code, mdutils.Whites)
-			s.Emit(rest[:i], md.Code{Code: code}, true)
+			s.Emit(rest[:i], md.Code{Code: code, Raw: s.Raw(rest[:i])}, true)
 			return i
 		}
 		for i < len(rest) && rest[i] == '`' {
@@ -306,14 +377,14 @@ func DetectImage(s *Context) (consumed int) {
 	if !bytes.HasPrefix(rest, []byte(`![`)) {
 		return 0
 	}
-	m := reImageTagStarter.FindSubmatch(rest)
+	m := findSubmatchWithRest(reImageTagStarter, rest)
 	if m == nil {
 		return 2
 	}
 	altText, residual := m[1], m[3]
 
 	// e.g.: "] [ref id]" ?
-	r := reImageRef.FindSubmatch(residual)
+	r := findSubmatch(reImageRef, residual)
 	if r != nil {
 		tag := rest[:len(rest)-len(residual)+len(r[0])]
 		refID := mdutils.Simplify(r[1])
@@ -325,9 +396,7 @@ func DetectImage(s *Context) (consumed int) {
 		s.Emit(tag, md.Image{
 			AltText:     mdutils.DeEscape(string(altText)),
 			ReferenceID: refID,
-			RawEnd: md.Raw{
-				md.Run{-1, r[0]},
-			},
+			RawEnd:      s.Raw(r[0]),
 		}, true)
 		return len(tag)
 	}
@@ -340,7 +409,7 @@ func DetectImage(s *Context) (consumed int) {
 
 	// "neither of the above conditions"
 	closing := residual[:1]
-	r = reImageEmptyRef.FindSubmatch(residual)
+	r = findSubmatch(reImageEmptyRef, residual)
 	if r != nil {
 		closing = r[0]
 	}
@@ -348,25 +417,23 @@ func DetectImage(s *Context) (consumed int) {
 	s.Emit(tag, md.Image{
 		ReferenceID: mdutils.Simplify(altText),
 		AltText:     mdutils.DeEscape(string(altText)),
-		RawEnd: md.Raw{
-			md.Run{-1, closing},
-		},
+		RawEnd:      s.Raw(closing),
 	}, true)
 	return len(tag)
 }
 
 var (
-	reImageTagStarter = regexp.MustCompile(`^!\[(([^\\\[\]\` + "`" + `]|\\.)*)(\][\s\S]*)$`)
+	reImageTagStarter = regexp.MustCompile(`^!\[(([^\\\[\]\` + "`" + `]|\\.)*)(\])`)
 	reImageRef        = regexp.MustCompile(`^\]\s*\[(([^\\\[\]\` + "`" + `]|\\.)*)\]`)
 
 	reImageParen           = regexp.MustCompile(`^\]\s*\(`)
-	reImageURLWithoutAngle = regexp.MustCompile(`^\]\s*\(\s*([^\(\)<>\` + "`" + `\s]+)([\)\s][\s\S]*)$`)
+	reImageURLWithoutAngle = regexp.MustCompile(`^\]\s*\(\s*([^\(\)<>\` + "`" + `\s]+)([\)\s])`)
 	// NOTE(akavel): below regexp was in spec, but fixed so that final
 	// capture matches the above pattern, and passes
 	// "image/link_with_parenthesis" test case.
 	// reImageURLWithAngle    = regexp.MustCompile(`^\]\s*\(\s*<([^<>\` + "`" + `]*)>([\)][\s\S]+)$`)
 	// TODO(akavel): send below fix to the spec.
-	reImageURLWithAngle = regexp.MustCompile(`^\]\s*\(\s*<([^<>\` + "`" + `]*)>([\)\s][\s\S]*)$`)
+	reImageURLWithAngle = regexp.MustCompile(`^\]\s*\(\s*<([^<>\` + "`" + `]*)>([\)\s])`)
 
 	reImageAttrParen = regexp.MustCompile(`^\s*\)`)
 	reImageAttrTitle = regexp.MustCompile(`^\s*("(([^"\\\` + "`" + `]|\\.)*)"|'(([^'\\\` + "`" + `]|\\.)*)')\s*\)`)
@@ -383,9 +450,9 @@ func imageParen(s *Context, altText []byte, prefix int, residual []byte) (consum
 	}
 	// fmt.Println("yes ](")
 
-	r := reImageURLWithoutAngle.FindSubmatch(residual)
+	r := findSubmatchWithRest(reImageURLWithoutAngle, residual)
 	if r == nil {
-		r = reImageURLWithAngle.FindSubmatch(residual)
+		r = findSubmatchWithRest(reImageURLWithAngle, residual)
 	}
 	if r == nil {
 		// fmt.Println("no imgurl")
@@ -394,9 +461,9 @@ func imageParen(s *Context, altText []byte, prefix int, residual []byte) (consum
 	// fmt.Println("yes imgurl")
 	unprocessedSrc, attrs := r[1], r[2]
 
-	a := reImageAttrParen.FindSubmatch(attrs)
+	a := findSubmatch(reImageAttrParen, attrs)
 	if a == nil {
-		a = reImageAttrTitle.FindSubmatch(attrs)
+		a = findSubmatch(reImageAttrTitle, attrs)
 	}
 	if a == nil {
 		// fmt.Println("no imgattr")
@@ -416,9 +483,7 @@ func imageParen(s *Context, altText []byte, prefix int, residual []byte) (consum
 		URL:     mdutils.DelWhites(string(unprocessedSrc)),
 		Title:   mdutils.DeEscape(title),
 		AltText: mdutils.DeEscape(string(altText)),
-		RawEnd: md.Raw{
-			md.Run{-1, tag[prefix:]},
-		},
+		RawEnd:  s.Raw(tag[prefix:]),
 	}, true)
 	return len(tag)
 }
@@ -435,10 +500,10 @@ func DetectAutomaticLink(s *Context) (consumed int) {
 	// fmt.Printf("potential autolink start at %d: %-15q...\n",
 	// 	s.Pos, string(rest))
 	// e.g. "<http://example.net>"
-	m := reURLWithinAngle.FindSubmatch(rest)
+	m := findSubmatch(reURLWithinAngle, rest)
 	// e.g. "<mailto:someone@example.net?subject=Hi+there>"
 	if m == nil {
-		m = reMailtoURLWithinAngle.FindSubmatch(rest)
+		m = findSubmatch(reMailtoURLWithinAngle, rest)
 	}
 	if m != nil {
 		url := mdutils.DelWhites(string(m[1]))
@@ -450,7 +515,7 @@ func DetectAutomaticLink(s *Context) (consumed int) {
 	}
 
 	// e.g.: "<someone@example.net>"
-	m = reMailWithinAngle.FindSubmatch(rest)
+	m = findSubmatch(reMailWithinAngle, rest)
 	if m != nil {
 		s.Emit(m[0], md.AutomaticLink{
 			URL:  "mailto:" + string(m[1]),
@@ -460,9 +525,9 @@ func DetectAutomaticLink(s *Context) (consumed int) {
 	}
 
 	// e.g.: "http://example.net"
-	m = reURLWithoutAngle.FindSubmatch(rest)
+	m = findSubmatch(reURLWithoutAngle, rest)
 	if m == nil {
-		m = reMailtoURLWithoutAngle.FindSubmatch(rest)
+		m = findSubmatch(reMailtoURLWithoutAngle, rest)
 	}
 	if m != nil {
 		// fmt.Printf("matched url w/o angle at %d: %s\n",
@@ -508,3 +573,91 @@ func isWordSep(r rune) bool {
 func isSpeculativeURLEnd(r rune) bool {
 	return r != '\u002f' && isWordSep(r)
 }
+
+var (
+	reHTMLOpeningTag = regexp.MustCompile(`^<[A-Za-z][A-Za-z0-9\-]*` +
+		`(\s+[A-Za-z_:][A-Za-z0-9_\.:\-]*(\s*=\s*([^\s"'=<>\` + "`" + `]+|'[^']*'|"[^"]*"))?)*` +
+		`\s*/?>`)
+	reHTMLClosingTag = regexp.MustCompile(`^</[A-Za-z][A-Za-z0-9\-]*\s*>`)
+)
+
+func DetectHTML(s *Context) (consumed int) {
+	rest := s.Buf[s.Pos:]
+	if len(rest) < 3 || rest[0] != '<' {
+		return 0
+	}
+	// e.g.: "<!-- comment -->"
+	if bytes.HasPrefix(rest, []byte("<!--")) {
+		if s.noCommentEnd {
+			return 0
+		}
+		end := bytes.Index(rest[4:], []byte("-->"))
+		if end == -1 {
+			s.noCommentEnd = true
+			return 0
+		}
+		tag := rest[:4+end+3]
+		s.Emit(tag, md.HTMLTag{HTML: tag}, true)
+		return len(tag)
+	}
+	// e.g.: "<kbd>", "<img src='x.png' />", "</kbd>"
+	m := reHTMLOpeningTag.FindIndex(rest)
+	if m == nil {
+		m = reHTMLClosingTag.FindIndex(rest)
+	}
+	if m == nil {
+		return 0
+	}
+	tag := rest[:m[1]]
+	s.Emit(tag, md.HTMLTag{HTML: tag}, true)
+	return len(tag)
+}
+
+var reEntity = regexp.MustCompile(`^&(#[0-9]{1,7}|#[xX][0-9a-fA-F]{1,6}|[A-Za-z][A-Za-z0-9]{1,31});`)
+
+func DetectEntity(s *Context) (consumed int) {
+	rest := s.Buf[s.Pos:]
+	if rest[0] != '&' {
+		return 0
+	}
+	m := reEntity.FindSubmatchIndex(rest)
+	if m == nil {
+		return 0
+	}
+	text := rest[:m[1]]
+	s.Emit(text, md.Entity{
+		Text:  text,
+		Runes: decodeEntity(string(text), string(rest[m[2]:m[3]])),
+	}, true)
+	return len(text)
+}
+
+// decodeEntity returns the characters represented by the entity reference
+// text, with name being its part between '&' and ';'. It returns nil if the
+// reference is not valid.
+func decodeEntity(text, name string) []rune {
+	if name[0] == '#' {
+		var n uint64
+		var err error
+		if name[1] == 'x' || name[1] == 'X' {
+			n, err = strconv.ParseUint(name[2:], 16, 32)
+		} else {
+			n, err = strconv.ParseUint(name[1:], 10, 32)
+		}
+		if err != nil || n == 0 || n > unicode.MaxRune || (n >= 0xD800 && n <= 0xDFFF) {
+			return nil
+		}
+	}
+	decoded := html.UnescapeString(text)
+	if decoded == text {
+		return nil
+	}
+	// Legacy names, like "amp" or "not", are decoded by html.UnescapeString
+	// even when followed by other characters, e.g. "&ampfoo;" becomes
+	// "&foo;". The rest of the name is then left in the result, with the ';'
+	// (which no entity other than "&semi;" decodes to).
+	if name[0] != '#' && decoded != ";" && strings.HasSuffix(decoded, ";") {
+		return nil
+	}
+	return []rune(decoded)
+}
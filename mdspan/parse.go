@@ -76,17 +76,68 @@ type Context struct {
 	Pos      int
 	Openings OpeningsStack
 	Spans    []Span
+
+	// region is the input, as split into lines; starts contains offsets in
+	// Buf where subsequent runs of region begin.
+	region md.Region
+	starts []int
+	// noCommentEnd is set by DetectHTML when "-->" was not found in Buf
+	// after an opening "<!--", so that further openings don't search again.
+	noCommentEnd bool
 }
 
+// Parse parses spans in buf. Positions of the resulting tags are not known,
+// so they have md.Run.Line set to -1.
 func Parse(buf []byte, detectors []Detector) []md.Tag {
+	return ParseRegion(md.Region{{Line: -1, Bytes: buf}}, detectors)
+}
+
+// ParseRegion parses spans in the text of region, which may span multiple
+// lines. All md.Run values in the resulting tags are subslices of the runs of
+// region, with their Line set accordingly.
+func ParseRegion(region md.Region, detectors []Detector) []md.Tag {
+	return ParseRegionLimits(region, detectors, Limits{})
+}
+
+// Limits restrict resources used for parsing spans of a region, to protect
+// against malicious input. Zero values mean no limit.
+type Limits struct {
+	// MaxOpenings is the maximum size of the stack of potential span
+	// openings. Openings above the limit are treated as plain text.
+	MaxOpenings int
+	// MaxSpans is the maximum number of detected spans (counting opening
+	// and closing tags separately). When it is reached, the rest of the
+	// region is treated as plain text. Note that a single detector may
+	// exceed the limit slightly, e.g. when emitting both tags of a link.
+	MaxSpans int
+}
+
+// ParseRegionLimits works like ParseRegion, with resources restricted by
+// limits.
+func ParseRegionLimits(region md.Region, detectors []Detector, limits Limits) []md.Tag {
 	if detectors == nil {
 		detectors = DefaultDetectors
 	}
-	s := Context{Buf: buf}
+	s := Context{region: region}
+	if len(region) == 1 {
+		// Avoid a copy; spans will be subslices of the input.
+		s.Buf, s.starts = region[0].Bytes, []int{0}
+	} else {
+		for _, run := range region {
+			s.starts = append(s.starts, len(s.Buf))
+			s.Buf = append(s.Buf, run.Bytes...)
+		}
+	}
 walk:
 	for s.Pos < len(s.Buf) {
+		if limits.MaxSpans > 0 && len(s.Spans) >= limits.MaxSpans {
+			break
+		}
 		for _, d := range detectors {
 			consumed := d.Detect(&s)
+			if limits.MaxOpenings > 0 && len(s.Openings) > limits.MaxOpenings {
+				s.Openings = s.Openings[:limits.MaxOpenings]
+			}
 			if consumed > 0 {
 				// fmt.Printf("DBG %T consumed %v at %v\t[%q]\n",
 				// 	d, consumed, s.Pos, string(s.Buf[s.Pos:s.Pos+consumed]))
@@ -101,13 +152,9 @@ walk:
 	tags := []md.Tag{}
 	endOffset := 0
 	for _, span := range s.Spans {
-		offset, _ := mdutils.OffsetIn(buf, span.Pos)
+		offset, _ := mdutils.OffsetIn(s.Buf, span.Pos)
 		if offset > endOffset {
-			// FIXME(akavel): for every "  \n" sequence, insert an md.HardBreak tag
-			tags = append(tags, mdutils.DeEscapeProse(md.Prose{
-				// FIXME(akavel): fix Line in md.Run
-				md.Run{-1, buf[endOffset:offset]},
-			}))
+			tags = append(tags, mdutils.DeEscapeProse(md.Prose(s.sub(endOffset, offset))))
 		}
 		tags = append(tags, span.Tag)
 		if span.SelfClose {
@@ -115,15 +162,51 @@ walk:
 		}
 		endOffset = offset + len(span.Pos)
 	}
-	if endOffset < len(buf) {
-		tags = append(tags, mdutils.DeEscapeProse(md.Prose{
-			// FIXME(akavel): fix Line in md.Run
-			md.Run{-1, buf[endOffset:]},
-		}))
+	if endOffset < len(s.Buf) {
+		tags = append(tags, mdutils.DeEscapeProse(md.Prose(s.sub(endOffset, len(s.Buf)))))
 	}
 	return tags
 }
 
+// Raw returns the fragment of the input region corresponding to slice, which
+// must be a subslice of s.Buf.
+func (s *Context) Raw(slice []byte) md.Raw {
+	offset, ok := mdutils.OffsetIn(s.Buf, slice)
+	if !ok {
+		return nil
+	}
+	return s.sub(offset, offset+len(slice))
+}
+
+// sub returns the fragment of the input region corresponding to
+// s.Buf[begin:end].
+func (s *Context) sub(begin, end int) md.Raw {
+	raw := md.Raw{}
+	// Find the last run starting at or before begin.
+	first := sort.Search(len(s.starts), func(i int) bool { return s.starts[i] > begin }) - 1
+	if first < 0 {
+		first = 0
+	}
+	for i := first; i < len(s.region) && s.starts[i] < end; i++ {
+		run := s.region[i]
+		lo, hi := begin-s.starts[i], end-s.starts[i]
+		if lo < 0 {
+			lo = 0
+		}
+		if hi > len(run.Bytes) {
+			hi = len(run.Bytes)
+		}
+		if lo >= hi {
+			continue
+		}
+		raw = append(raw, md.Run{
+			Line:  run.Line,
+			Bytes: run.Bytes[lo:hi],
+		})
+	}
+	return raw
+}
+
 type sortedSpans []Span
 
 func (s sortedSpans) Len() int      { return len(s) }
@@ -113,7 +113,7 @@ func TestSpan(test *testing.T) {
 			md.AutomaticLink{URL: "feed://example.net/rss.xml", Text: "feed://example.net/rss.xml"},
 			md.AutomaticLink{URL: "googlechrome://example.net/", Text: "googlechrome://example.net/"},
 			md.AutomaticLink{URL: "googlechrome://example.net/", Text: "googlechrome://example.net/"},
-			md.Code{bb("<>")},
+			md.Code{Code: bb("<>")},
 			// NOTE(akavel): below line is unexpected according to
 			// testdata/, but from spec this seems totally expected,
 			// so I added it
@@ -141,94 +141,94 @@ func TestSpan(test *testing.T) {
 			md.AutomaticLink{URL: "ftp://example.net/path/", Text: "ftp://example.net/path/"},
 		}),
 		lines("code/end_of_codespan.md", spans{
-			md.Code{bb("code span")},
-			md.Code{bb("code span` ends")},
-			md.Code{bb("code span`` ends")},
-			md.Code{bb("code span`` ``ends")},
-			md.Code{bb(`code span\`)},
+			md.Code{Code: bb("code span")},
+			md.Code{Code: bb("code span` ends")},
+			md.Code{Code: bb("code span`` ends")},
+			md.Code{Code: bb("code span`` ``ends")},
+			md.Code{Code: bb(`code span\`)},
 		}),
 		blocks("code/multiline.md", spans{
-			md.Code{bb("code span\ncan span multiple\nlines")},
+			md.Code{Code: bb("code span\ncan span multiple\nlines")},
 		}),
 		lines("code/vs_emph.md", spans{
-			md.Code{bb("code containing *em* text")},
-			md.Code{bb("code containing **strong** text")},
-			md.Code{bb("code containing _em_ text")},
-			md.Code{bb("code containing __strong__ text")},
+			md.Code{Code: bb("code containing *em* text")},
+			md.Code{Code: bb("code containing **strong** text")},
+			md.Code{Code: bb("code containing _em_ text")},
+			md.Code{Code: bb("code containing __strong__ text")},
 
 			md.Emphasis{Level: 1},
-			md.Code{bb("code")},
+			md.Code{Code: bb("code")},
 			md.End{},
 			md.Emphasis{Level: 2},
-			md.Code{bb("code")},
+			md.Code{Code: bb("code")},
 			md.End{},
 			md.Emphasis{Level: 1},
-			md.Code{bb("code")},
+			md.Code{Code: bb("code")},
 			md.End{},
 			md.Emphasis{Level: 2},
-			md.Code{bb("code")},
+			md.Code{Code: bb("code")},
 			md.End{},
 
-			md.Code{bb("code *intertwined")},
-			md.Code{bb("with em* text")},
-			md.Code{bb("code **intertwined")},
-			md.Code{bb("with strong** text")},
-			md.Code{bb("code _intertwined")},
-			md.Code{bb("with em_ text")},
-			md.Code{bb("code __intertwined")},
-			md.Code{bb("with strong__ text")},
+			md.Code{Code: bb("code *intertwined")},
+			md.Code{Code: bb("with em* text")},
+			md.Code{Code: bb("code **intertwined")},
+			md.Code{Code: bb("with strong** text")},
+			md.Code{Code: bb("code _intertwined")},
+			md.Code{Code: bb("with em_ text")},
+			md.Code{Code: bb("code __intertwined")},
+			md.Code{Code: bb("with strong__ text")},
 		}),
 		lines("code/vs_image.md", spans{
-			md.Code{bb("code containing ![image](url)")},
-			md.Code{bb("code containing ![image][ref]")},
-			md.Code{bb("code containing ![ref]")},
+			md.Code{Code: bb("code containing ![image](url)")},
+			md.Code{Code: bb("code containing ![image][ref]")},
+			md.Code{Code: bb("code containing ![ref]")},
 
-			md.Code{bb("containing code")},
-			md.Code{bb("containing code")},
+			md.Code{Code: bb("containing code")},
+			md.Code{Code: bb("containing code")},
 			md.Link{ReferenceID: "ref", RawEnd: md.Raw{{-1, bb("]")}}},
 			md.End{},
-			md.Code{bb("containing code")},
+			md.Code{Code: bb("containing code")},
 
-			md.Code{bb("code ![intertwined")},
-			md.Code{bb("intertwined](with) image")},
-			md.Code{bb("code ![intertwined")},
+			md.Code{Code: bb("code ![intertwined")},
+			md.Code{Code: bb("intertwined](with) image")},
+			md.Code{Code: bb("code ![intertwined")},
 			md.Link{ReferenceID: "ref", RawEnd: md.Raw{{-1, bb("]")}}},
 			md.End{},
-			md.Code{bb("intertwined with][ref] image")},
-			md.Code{bb("code ![intertwined")},
-			md.Code{bb("with] image")},
+			md.Code{Code: bb("intertwined with][ref] image")},
+			md.Code{Code: bb("code ![intertwined")},
+			md.Code{Code: bb("with] image")},
 		}, head(30)),
 		lines("code/vs_link.md", spans{
-			md.Code{bb("code containing [link](url)")},
-			md.Code{bb("code containing [link][ref]")},
-			md.Code{bb("code containing [ref]")},
+			md.Code{Code: bb("code containing [link](url)")},
+			md.Code{Code: bb("code containing [link][ref]")},
+			md.Code{Code: bb("code containing [ref]")},
 
 			md.Link{URL: "url", RawEnd: md.Raw{{-1, bb("](url)")}}},
-			md.Code{bb("containing code")},
+			md.Code{Code: bb("containing code")},
 
 			md.End{},
 			md.Link{ReferenceID: "ref", RawEnd: md.Raw{{-1, bb("][ref]")}}},
-			md.Code{bb("containing code")},
+			md.Code{Code: bb("containing code")},
 			md.End{},
 			md.Link{ReferenceID: "link `containing code`", RawEnd: md.Raw{{-1, bb("]")}}},
-			md.Code{bb("containing code")},
+			md.Code{Code: bb("containing code")},
 			md.End{},
 
-			md.Code{bb("code [intertwined")},
-			md.Code{bb("intertwined](with) link")},
-			md.Code{bb("code [intertwined")},
+			md.Code{Code: bb("code [intertwined")},
+			md.Code{Code: bb("intertwined](with) link")},
+			md.Code{Code: bb("code [intertwined")},
 			md.Link{ReferenceID: "ref", RawEnd: md.Raw{{-1, bb("]")}}},
 			md.End{},
-			md.Code{bb("intertwined with][ref] link")},
-			md.Code{bb("code [intertwined")},
-			md.Code{bb("with] link")},
+			md.Code{Code: bb("intertwined with][ref] link")},
+			md.Code{Code: bb("code [intertwined")},
+			md.Code{Code: bb("with] link")},
 		}, head(30)),
 		lines("code/well_formed.md", spans{
-			md.Code{bb("code span")},
-			md.Code{bb("code ` span")},
-			md.Code{bb("` code span")},
-			md.Code{bb("code span `")},
-			md.Code{bb("`code span`")},
+			md.Code{Code: bb("code span")},
+			md.Code{Code: bb("code ` span")},
+			md.Code{Code: bb("` code span")},
+			md.Code{Code: bb("code span `")},
+			md.Code{Code: bb("`code span`")},
 		}),
 		lines("emphasis/emphasis_tag_combinations.md", spans{
 			emB("*"), emB("__"), emE("__"), emE("*"),
@@ -440,13 +440,13 @@ func TestSpan(test *testing.T) {
 			md.Image{AltText: "link", URL: "url1", RawEnd: md.Raw{{-1, bb("](<url \n   1>)")}}},
 		}, head(6)),
 		lines("image/vs_code.md", spans{
-			md.Code{bb("code")},
-			md.Code{bb("containing ![image](url)")},
-			md.Code{bb("containing ![image][ref]")},
+			md.Code{Code: bb("code")},
+			md.Code{Code: bb("containing ![image](url)")},
+			md.Code{Code: bb("containing ![image][ref]")},
 			md.Link{ReferenceID: "ref", RawEnd: md.Raw{{-1, bb("]")}}},
 			md.End{},
-			md.Code{bb("intertwined](url) with code")},
-			md.Code{bb("intertwined ![with code")},
+			md.Code{Code: bb("intertwined](url) with code")},
+			md.Code{Code: bb("intertwined ![with code")},
 		}),
 		lines("image/vs_emph.md", spans{
 			md.Image{URL: "url", AltText: "image containing *em* text", RawEnd: md.Raw{{-1, bb("](url)")}}},
@@ -639,14 +639,14 @@ func TestSpan(test *testing.T) {
 		}, head(6)),
 		lines("link/vs_code.md", spans{
 			md.Link{URL: "url", RawEnd: md.Raw{{-1, bb("](url)")}}},
-			md.Code{bb("code")},
+			md.Code{Code: bb("code")},
 			md.End{},
-			md.Code{bb("containing [link](url)")},
-			md.Code{bb("containing [link][ref]")},
+			md.Code{Code: bb("containing [link](url)")},
+			md.Code{Code: bb("containing [link][ref]")},
 			md.Link{ReferenceID: "ref", RawEnd: md.Raw{{-1, bb("]")}}},
 			md.End{},
-			md.Code{bb("intertwined](url) with code")},
-			md.Code{bb("intertwined [with code")},
+			md.Code{Code: bb("intertwined](url) with code")},
+			md.Code{Code: bb("intertwined [with code")},
 		}),
 		lines("link/vs_emph.md", spans{
 			md.Link{URL: "url", RawEnd: md.Raw{{-1, bb("](url)")}}},
@@ -698,7 +698,13 @@ func TestSpan(test *testing.T) {
 		}
 		spans := []md.Tag{}
 		for _, t := range tags {
-			if _, ok := t.(md.Prose); !ok {
+			switch tt := t.(type) {
+			case md.Prose:
+			case md.Code:
+				// Positions are verified separately.
+				tt.Raw = nil
+				spans = append(spans, tt)
+			default:
 				spans = append(spans, t)
 			}
 		}
@@ -710,6 +716,97 @@ func TestSpan(test *testing.T) {
 	}
 }
 
+func TestEntity(test *testing.T) {
+	cases := []struct {
+		buf   string
+		runes []rune
+	}{
+		{"&amp;", []rune("&")},
+		{"&nbsp;", []rune("\u00a0")},
+		{"&#169;", []rune("\u00a9")},
+		{"&#x1F600;", []rune("\U0001F600")},
+		{"&NotNestedGreaterGreater;", []rune("\u2aa2\u0338")},
+		{"&nosuchentity;", nil},
+		{"&ampfoo;", nil},
+		{"&notit;", nil},
+		{"&copyright;", nil},
+		{"&semi;", []rune(";")},
+		{"&not;", []rune("\u00ac")},
+		{"&#0;", nil},
+		{"&#xDFFF;", nil},
+	}
+	for _, c := range cases {
+		expected := []md.Tag{md.Entity{Text: bb(c.buf), Runes: c.runes}, md.End{}}
+		tags := Parse(bb(c.buf), nil)
+		if !reflect.DeepEqual(expected, tags) {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.buf, diff.Diff(spew.Sdump(expected), spew.Sdump(tags)))
+		}
+	}
+}
+
+func TestHTMLComment(test *testing.T) {
+	cases := []struct {
+		buf  string
+		tags []string
+	}{
+		{"<!-- a -->", []string{"<!-- a -->"}},
+		{"<!-- a <!-- b -->", []string{"<!-- a <!-- b -->"}},
+		{"<!-- a --> <!-- b", []string{"<!-- a -->"}},
+		{"<!-- a <!-- b <!-- c", nil},
+		{"<!-- a <!-- b <kbd>", []string{"<kbd>"}},
+	}
+	for _, c := range cases {
+		var tags []string
+		for _, t := range Parse(bb(c.buf), nil) {
+			if t, ok := t.(md.HTMLTag); ok {
+				tags = append(tags, string(t.HTML))
+			}
+		}
+		if !reflect.DeepEqual(c.tags, tags) {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.buf, diff.Diff(spew.Sdump(c.tags), spew.Sdump(tags)))
+		}
+	}
+}
+
+func TestBackslashHardBreak(test *testing.T) {
+	detectors := append([]Detector{DetectorFunc(DetectBackslashHardBreak)}, DefaultDetectors...)
+	tags := Parse(bb("a\\\nb\\*c"), detectors)
+	expected := []md.Tag{
+		md.Prose{{-1, bb("a")}},
+		md.HardBreak{}, md.End{},
+		md.Prose{{-1, bb("b")}, {-1, bb("*c")}},
+	}
+	if !reflect.DeepEqual(expected, tags) {
+		test.Errorf("expected vs. got DIFF:\n%s",
+			diff.Diff(spew.Sdump(expected), spew.Sdump(tags)))
+	}
+}
+
+func TestRegionPositions(test *testing.T) {
+	region := md.Region{
+		{3, bb("a `co\n")},
+		{4, bb("de` [b](\n")},
+		{5, bb("/url) c")},
+	}
+	tags := ParseRegion(region, nil)
+	expected := []md.Tag{
+		md.Prose{{3, bb("a ")}},
+		md.Code{Code: bb("co\nde"), Raw: md.Raw{{3, bb("`co\n")}, {4, bb("de`")}}},
+		md.End{},
+		md.Prose{{4, bb(" ")}},
+		md.Link{URL: "/url", RawEnd: md.Raw{{4, bb("](\n")}, {5, bb("/url)")}}},
+		md.Prose{{4, bb("b")}},
+		md.End{},
+		md.Prose{{5, bb(" c")}},
+	}
+	if !reflect.DeepEqual(expected, tags) {
+		test.Errorf("expected vs. got DIFF:\n%s",
+			diff.Diff(spew.Sdump(expected), spew.Sdump(tags)))
+	}
+}
+
 func init() {
 	spew.Config.Indent = "  "
 }
@@ -724,3 +821,39 @@ emphasis/vs_html.md
 image/vs_html.md
 link/vs_html.md
 */
+
+func TestLimits(test *testing.T) {
+	cases := []struct {
+		buf      string
+		limits   Limits
+		expected []md.Tag
+	}{{
+		buf:    "*a* *b* *c*",
+		limits: Limits{MaxSpans: 2},
+		expected: []md.Tag{
+			md.Emphasis{Level: 1}, md.Prose{{-1, bb("a")}}, md.End{},
+			md.Prose{{-1, bb(" *b* *c*")}},
+		},
+	}, {
+		buf:    "*a _b c_ d*",
+		limits: Limits{MaxOpenings: 1},
+		expected: []md.Tag{
+			md.Emphasis{Level: 1}, md.Prose{{-1, bb("a _b c_ d")}}, md.End{},
+		},
+	}, {
+		buf:    "*a _b c_ d*",
+		limits: Limits{MaxOpenings: 2},
+		expected: []md.Tag{
+			md.Emphasis{Level: 1}, md.Prose{{-1, bb("a ")}},
+			md.Emphasis{Level: 1}, md.Prose{{-1, bb("b c")}}, md.End{},
+			md.Prose{{-1, bb(" d")}}, md.End{},
+		},
+	}}
+	for _, c := range cases {
+		tags := ParseRegionLimits(md.Region{{-1, bb(c.buf)}}, nil, c.limits)
+		if !reflect.DeepEqual(c.expected, tags) {
+			test.Errorf("case %q %+v expected vs. got DIFF:\n%s",
+				c.buf, c.limits, diff.Diff(spew.Sdump(c.expected), spew.Sdump(tags)))
+		}
+	}
+}
@@ -0,0 +1,168 @@
+// Package mdtree converts the flat stream of tags emitted by mdblock and
+// mdspan into a tree of nodes, and back.
+//
+// In the flat stream, every tag except md.Prose is followed by its children
+// (if any), and then by a closing md.End{}. Self-closing spans (like
+// md.Image) are followed directly by md.End{}.
+package mdtree // import "gopkg.in/akavel/vfmd.v1/mdtree"
+
+import "gopkg.in/akavel/vfmd.v1/md"
+
+// Node is a tag together with its position in the tree.
+type Node struct {
+	// Tag is nil for the root node returned from Build.
+	Tag      md.Tag
+	Parent   *Node
+	Children []*Node
+
+	// index of the node in Parent.Children
+	index int
+}
+
+// HasEnd reports whether tag is closed by md.End{} in the flat stream. This
+// is true for all tags except md.Prose.
+func HasEnd(tag md.Tag) bool {
+	_, prose := tag.(md.Prose)
+	return !prose
+}
+
+// EndOf returns the index of the md.End{} closing the current level of the
+// flat stream, i.e. the first one not matching a tag in tags, or -1 if there
+// is none.
+func EndOf(tags []md.Tag) int {
+	depth := 0
+	for i, t := range tags {
+		switch {
+		case (t == md.End{}):
+			if depth == 0 {
+				return i
+			}
+			depth--
+		case HasEnd(t):
+			depth++
+		}
+	}
+	return -1
+}
+
+// CountItems returns the number of md.ItemBlock tags at the current level of
+// the flat stream, e.g. the number of items in a list, if tags start with its
+// first item.
+func CountItems(tags []md.Tag) int {
+	n, depth := 0, 0
+	for _, t := range tags {
+		switch {
+		case (t == md.End{}):
+			if depth == 0 {
+				return n
+			}
+			depth--
+		case HasEnd(t):
+			if _, ok := t.(md.ItemBlock); ok && depth == 0 {
+				n++
+			}
+			depth++
+		}
+	}
+	return n
+}
+
+// Items calls item for every md.ItemBlock of a list, if *tags start with its
+// first item, and moves *tags past the closing md.End{} of the list. When item
+// is called, *tags start with the children of the md.ItemBlock, and item must
+// move them past its closing md.End{}. Items stops at the first error returned
+// from item.
+func Items(tags *[]md.Tag, item func(i int, t md.ItemBlock) error) error {
+	for i := 0; ; i++ {
+		if len(*tags) == 0 {
+			return md.RenderErrorf(*tags, "missing md.End")
+		}
+		if ((*tags)[0] == md.End{}) {
+			*tags = (*tags)[1:]
+			return nil
+		}
+		t, ok := (*tags)[0].(md.ItemBlock)
+		if !ok {
+			return md.RenderErrorf(*tags, "expected md.ItemBlock, got %T", (*tags)[0])
+		}
+		*tags = (*tags)[1:]
+		if err := item(i, t); err != nil {
+			return err
+		}
+	}
+}
+
+// Build creates a tree from a flat stream of tags, returning its root node.
+// If the tags are not properly balanced with md.End{}, it returns a
+// *md.RenderError pointing at the offending tag.
+func Build(tags []md.Tag) (*Node, error) {
+	root := &Node{}
+	n := root
+	// indexes of the open tags in tags
+	open := []int{}
+	for i, t := range tags {
+		if (t == md.End{}) {
+			if n == root {
+				return nil, md.RenderErrorf(tags[i:], "unexpected md.End")
+			}
+			n = n.Parent
+			open = open[:len(open)-1]
+			continue
+		}
+		child := n.add(t)
+		if HasEnd(t) {
+			n = child
+			open = append(open, i)
+		}
+	}
+	if n != root {
+		i := open[len(open)-1]
+		return nil, md.RenderErrorf(tags[i:], "missing md.End for %T", tags[i])
+	}
+	return root, nil
+}
+
+func (n *Node) add(tag md.Tag) *Node {
+	child := &Node{Tag: tag, Parent: n, index: len(n.Children)}
+	n.Children = append(n.Children, child)
+	return child
+}
+
+// NextSibling returns the next child of n's parent, or nil if none.
+func (n *Node) NextSibling() *Node {
+	if n.Parent == nil || n.index+1 >= len(n.Parent.Children) {
+		return nil
+	}
+	return n.Parent.Children[n.index+1]
+}
+
+// PrevSibling returns the previous child of n's parent, or nil if none.
+func (n *Node) PrevSibling() *Node {
+	if n.Parent == nil || n.index == 0 {
+		return nil
+	}
+	return n.Parent.Children[n.index-1]
+}
+
+// Flatten serializes the subtree rooted at n back into a flat stream of
+// tags. The root node returned from Build is not included in the stream.
+func (n *Node) Flatten() []md.Tag {
+	return n.flatten(nil)
+}
+
+func (n *Node) flatten(tags []md.Tag) []md.Tag {
+	if n.Tag == nil {
+		for _, c := range n.Children {
+			tags = c.flatten(tags)
+		}
+		return tags
+	}
+	tags = append(tags, n.Tag)
+	if !HasEnd(n.Tag) {
+		return tags
+	}
+	for _, c := range n.Children {
+		tags = c.flatten(tags)
+	}
+	return append(tags, md.End{})
+}
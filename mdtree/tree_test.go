@@ -0,0 +1,128 @@
+package mdtree
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1"
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+)
+
+const sample = `# Title with [link](http://x.org)
+
+* item *one*
+* item ![two](two.png)
+
+> quote
+`
+
+func parse(test *testing.T, input string) []md.Tag {
+	prep, _ := vfmd.QuickPrep(strings.NewReader(input))
+	tags, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	return tags
+}
+
+func TestBuildFlatten(test *testing.T) {
+	tags := parse(test, sample)
+	root, err := Build(tags)
+	if err != nil {
+		test.Fatal(err)
+	}
+	flat := root.Flatten()
+	if !reflect.DeepEqual(tags, flat) {
+		test.Errorf("expected vs. got DIFF:\n%s",
+			diff.Diff(spew.Sdump(tags), spew.Sdump(flat)))
+	}
+
+	header := root.Children[0]
+	if _, ok := header.Tag.(md.AtxHeaderBlock); !ok {
+		test.Fatalf("expected md.AtxHeaderBlock, got %T", header.Tag)
+	}
+	link := header.Children[1]
+	if _, ok := link.Tag.(md.Link); !ok || link.Parent != header {
+		test.Fatalf("expected md.Link child of header, got %T", link.Tag)
+	}
+	if len(link.Children) != 1 || link.PrevSibling() != header.Children[0] || link.NextSibling() != nil {
+		test.Errorf("unexpected neighbours of md.Link: %v", link)
+	}
+	list := header.NextSibling().NextSibling()
+	if _, ok := list.Tag.(md.UnorderedListBlock); !ok || len(list.Children) != 2 {
+		test.Fatalf("expected md.UnorderedListBlock with 2 items, got %T", list.Tag)
+	}
+	if root.PrevSibling() != nil || root.NextSibling() != nil {
+		test.Errorf("expected root without siblings")
+	}
+}
+
+func TestBuildErrors(test *testing.T) {
+	cases := []struct {
+		tags []md.Tag
+		bad  int // index of the offending tag
+	}{
+		{[]md.Tag{md.End{}}, 0},
+		{[]md.Tag{md.ParagraphBlock{}, md.Prose{}}, 0},
+		{[]md.Tag{md.QuoteBlock{}, md.ParagraphBlock{}, md.End{}}, 0},
+		{[]md.Tag{md.ParagraphBlock{}, md.End{}, md.End{}}, 2},
+	}
+	for _, c := range cases {
+		_, err := Build(c.tags)
+		checkTreeError(test, c.tags, c.bad, err)
+	}
+}
+
+// checkTreeError checks that err is a *md.RenderError for tags[bad].
+func checkTreeError(test *testing.T, tags []md.Tag, bad int, err error) {
+	rerr, ok := err.(*md.RenderError)
+	if !ok {
+		test.Errorf("case %s expected RenderError, got %v", spew.Sdump(tags), err)
+		return
+	}
+	if len(rerr.Tags) != len(tags)-bad || &rerr.Tags[0] != &tags[bad] {
+		test.Errorf("case %s expected error at tag %d, got %d tags remaining: %s",
+			spew.Sdump(tags), bad, len(rerr.Tags), err)
+	}
+}
+
+func TestEndOfItems(test *testing.T) {
+	tags := parse(test, "* a\n* b\n  * c\n\n> q\n")
+	// Skip the md.UnorderedListBlock, to start at its first item.
+	list := tags[1:]
+	end := EndOf(list)
+	if _, ok := list[end+1].(md.QuoteBlock); !ok {
+		test.Fatalf("expected md.QuoteBlock after the list, got %T", list[end+1])
+	}
+	if n := CountItems(list); n != 2 {
+		test.Errorf("expected 2 items, got %d", n)
+	}
+	var seen []int
+	err := Items(&list, func(i int, t md.ItemBlock) error {
+		seen = append(seen, i)
+		list = list[EndOf(list)+1:]
+		return nil
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !reflect.DeepEqual(seen, []int{0, 1}) {
+		test.Errorf("expected items [0 1], got %v", seen)
+	}
+	if _, ok := list[0].(md.QuoteBlock); !ok {
+		test.Errorf("expected md.QuoteBlock after Items, got %T", list[0])
+	}
+	if EndOf([]md.Tag{md.ParagraphBlock{}, md.End{}}) != -1 {
+		test.Errorf("expected -1 for a balanced stream")
+	}
+	list = []md.Tag{md.ParagraphBlock{}}
+	if Items(&list, func(int, md.ItemBlock) error { return nil }) == nil {
+		test.Errorf("expected error for a non-item tag")
+	}
+}
@@ -0,0 +1,129 @@
+package mdtree
+
+import "gopkg.in/akavel/vfmd.v1/md"
+
+// Action tells Walk how to proceed after calling a Visitor.
+type Action int
+
+const (
+	// Continue walks the tags as usual.
+	Continue Action = iota
+	// SkipChildren, when returned from Enter, makes Walk skip children of
+	// the tag, and call Leave for it immediately.
+	SkipChildren
+	// Stop ends the walk.
+	Stop
+)
+
+// Visitor is called by Walk for every tag. Every tag, including ones without
+// children, is first entered, then left. The md.End{} tags are not visited.
+//
+// There are no separate methods for each type of tag: like the renderers,
+// a Visitor is expected to use a type switch on the tag, which also works
+// for tag types defined outside of package md (like the ones in mdgithub).
+type Visitor interface {
+	Enter(tag md.Tag) Action
+	Leave(tag md.Tag) Action
+}
+
+// Funcs is a Visitor calling the specified functions. Nil functions are
+// treated as returning Continue.
+type Funcs struct {
+	EnterFunc func(md.Tag) Action
+	LeaveFunc func(md.Tag) Action
+}
+
+func (f Funcs) Enter(tag md.Tag) Action {
+	if f.EnterFunc == nil {
+		return Continue
+	}
+	return f.EnterFunc(tag)
+}
+
+func (f Funcs) Leave(tag md.Tag) Action {
+	if f.LeaveFunc == nil {
+		return Continue
+	}
+	return f.LeaveFunc(tag)
+}
+
+// Walk calls v for tags in a flat stream, in depth-first order. If tags are
+// not properly balanced with md.End{}, it returns a *md.RenderError pointing
+// at the offending tag.
+func Walk(tags []md.Tag, v Visitor) error {
+	// indexes of the entered tags, waiting for their md.End{}
+	stack := []int{}
+	for i := 0; i < len(tags); i++ {
+		t := tags[i]
+		if (t == md.End{}) {
+			if len(stack) == 0 {
+				return md.RenderErrorf(tags[i:], "unexpected md.End")
+			}
+			parent := tags[stack[len(stack)-1]]
+			stack = stack[:len(stack)-1]
+			if v.Leave(parent) == Stop {
+				return nil
+			}
+			continue
+		}
+		switch v.Enter(t) {
+		case Stop:
+			return nil
+		case SkipChildren:
+			if HasEnd(t) {
+				end, err := matchingEnd(tags, i)
+				if err != nil {
+					return err
+				}
+				i = end
+			}
+		default:
+			if HasEnd(t) {
+				stack = append(stack, i)
+				continue
+			}
+		}
+		if v.Leave(t) == Stop {
+			return nil
+		}
+	}
+	if len(stack) > 0 {
+		i := stack[len(stack)-1]
+		return md.RenderErrorf(tags[i:], "missing md.End for %T", tags[i])
+	}
+	return nil
+}
+
+// matchingEnd returns the index of md.End{} closing tags[i].
+func matchingEnd(tags []md.Tag, i int) (int, error) {
+	end := EndOf(tags[i+1:])
+	if end < 0 {
+		return -1, md.RenderErrorf(tags[i:], "missing md.End for %T", tags[i])
+	}
+	return i + 1 + end, nil
+}
+
+// Walk calls v for the subtree rooted at n, in depth-first order. The root
+// node returned from Build is not visited itself. It reports whether the walk
+// was stopped.
+func (n *Node) Walk(v Visitor) (stopped bool) {
+	if n.Tag == nil {
+		for _, c := range n.Children {
+			if c.Walk(v) {
+				return true
+			}
+		}
+		return false
+	}
+	switch v.Enter(n.Tag) {
+	case Stop:
+		return true
+	case Continue:
+		for _, c := range n.Children {
+			if c.Walk(v) {
+				return true
+			}
+		}
+	}
+	return v.Leave(n.Tag) == Stop
+}
@@ -0,0 +1,110 @@
+package mdtree
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+)
+
+// recorder logs visited tags, skipping children of lists and stopping at
+// images.
+type recorder struct{ log []string }
+
+func (r *recorder) Enter(tag md.Tag) Action {
+	r.log = append(r.log, fmt.Sprintf("enter %T", tag))
+	switch tag.(type) {
+	case md.OrderedListBlock:
+		return SkipChildren
+	case md.Image:
+		return Stop
+	}
+	return Continue
+}
+
+func (r *recorder) Leave(tag md.Tag) Action {
+	r.log = append(r.log, fmt.Sprintf("leave %T", tag))
+	return Continue
+}
+
+func TestWalk(test *testing.T) {
+	tags := parse(test, "Some *text*\n\n1. skipped *item*\n\n* ![image](x.png) unseen\n")
+	expected := strings.Join([]string{
+		"enter md.ParagraphBlock",
+		"enter md.Prose",
+		"leave md.Prose",
+		"enter md.Emphasis",
+		"enter md.Prose",
+		"leave md.Prose",
+		"leave md.Emphasis",
+		"leave md.ParagraphBlock",
+		"enter md.OrderedListBlock",
+		"leave md.OrderedListBlock",
+		"enter md.UnorderedListBlock",
+		"enter md.ItemBlock",
+		"enter md.ParagraphBlock",
+		"enter md.Image",
+	}, "\n")
+
+	r := &recorder{}
+	err := Walk(tags, r)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if got := strings.Join(r.log, "\n"); got != expected {
+		test.Errorf("flat walk expected vs. got DIFF:\n%s", diff.Diff(expected, got))
+	}
+
+	root, err := Build(tags)
+	if err != nil {
+		test.Fatal(err)
+	}
+	r = &recorder{}
+	if !root.Walk(r) {
+		test.Errorf("expected tree walk to be stopped")
+	}
+	if got := strings.Join(r.log, "\n"); got != expected {
+		test.Errorf("tree walk expected vs. got DIFF:\n%s", diff.Diff(expected, got))
+	}
+}
+
+func TestWalkFuncs(test *testing.T) {
+	words := 0
+	err := Walk(parse(test, sample), Funcs{
+		EnterFunc: func(tag md.Tag) Action {
+			if p, ok := tag.(md.Prose); ok {
+				for _, r := range p {
+					words += len(strings.Fields(string(r.Bytes)))
+				}
+			}
+			return Continue
+		},
+	})
+	if err != nil {
+		test.Fatal(err)
+	}
+	if words != 7 {
+		test.Errorf("expected 7 words, got %d", words)
+	}
+}
+
+func TestWalkErrors(test *testing.T) {
+	skip := Funcs{EnterFunc: func(md.Tag) Action { return SkipChildren }}
+	cases := []struct {
+		tags []md.Tag
+		v    Visitor
+		bad  int // index of the offending tag
+	}{
+		{[]md.Tag{md.ParagraphBlock{}}, Funcs{}, 0},
+		{[]md.Tag{md.QuoteBlock{}, md.ParagraphBlock{}, md.End{}}, Funcs{}, 0},
+		{[]md.Tag{md.ParagraphBlock{}, md.End{}, md.End{}}, Funcs{}, 2},
+		{[]md.Tag{md.QuoteBlock{}, md.ParagraphBlock{}, md.End{}}, skip, 0},
+	}
+	for _, c := range cases {
+		err := Walk(c.tags, c.v)
+		checkTreeError(test, c.tags, c.bad, err)
+	}
+}
@@ -16,6 +16,26 @@ func IsWhite(b byte) bool {
 	return b == 0x09 || b == 0x0a || b == 0x0c || b == 0x0d || b == 0x20
 }
 
+// IsBlank reports whether line contains only whitespace.
+func IsBlank(line []byte) bool {
+	return len(bytes.Trim(line, Whites)) == 0
+}
+
+// HasBlankLine reports whether raw contains a blank line, not counting the
+// trailing ones.
+func HasBlankLine(raw md.Raw) bool {
+	n := len(raw)
+	for n > 0 && IsBlank(raw[n-1].Bytes) {
+		n--
+	}
+	for _, r := range raw[:n] {
+		if IsBlank(r.Bytes) {
+			return true
+		}
+	}
+	return false
+}
+
 // FIXME(akavel): test if this works as expected
 var whitespaceDeleter = strings.NewReplacer("\u0009", "",
 	"\u000a", "",
@@ -1,33 +1,32 @@
 package vfmd // import "gopkg.in/akavel/vfmd.v1"
 
 import (
-	"bufio"
 	"bytes"
-	"fmt"
 	"io"
+	"io/ioutil"
 	"unicode/utf8"
 )
 
 func QuickPrep(r io.Reader) ([]byte, error) {
-	prep := Preprocessor{}
-	br := bufio.NewReader(r)
-	for {
-		b, err := br.ReadByte()
-		if err == io.EOF {
-			break
-		}
-		if err != nil {
-			return nil, err
-		}
-		prep.WriteByte(b)
+	return ioutil.ReadAll(NewReader(r))
+}
+
+// readAll preprocesses all data from r, and closes the Preprocessor.
+func (p *Preprocessor) readAll(r io.Reader) error {
+	_, err := io.Copy(p, r)
+	if err != nil {
+		return err
 	}
-	prep.Close()
+	return p.Close()
+}
 
+// bytes returns concatenated contents of all Chunks.
+func (p *Preprocessor) bytes() []byte {
 	buf := bytes.NewBuffer(nil)
-	for _, c := range prep.Chunks {
+	for _, c := range p.Chunks {
 		buf.Write(c.Bytes)
 	}
-	return buf.Bytes(), nil
+	return buf.Bytes()
 }
 
 type Preprocessor struct {
@@ -53,7 +52,8 @@ type Chunk struct {
 }
 
 // SourceLength returns length of the byte slice in the original stream that
-// was converted into this Chunk.
+// was converted into this Chunk. For an unknown Type, it returns length of
+// Bytes.
 func (c Chunk) SourceLength() int {
 	switch c.Type {
 	case ChunkIgnoredBOM:
@@ -69,7 +69,8 @@ func (c Chunk) SourceLength() int {
 	case ChunkConvertedISO8859_1:
 		return utf8.RuneCount(c.Bytes)
 	}
-	panic(fmt.Sprintf("unknown vfmd.Chunk type %d [%q]", c.Type, c.Bytes))
+	// Unknown type; assume the data was not changed.
+	return len(c.Bytes)
 }
 
 type ChunkType int
@@ -87,12 +88,43 @@ const (
 var _ io.Writer = &Preprocessor{}
 
 func (p *Preprocessor) Write(buf []byte) (int, error) {
-	for _, b := range buf {
-		p.WriteByte(b)
+	for i := 0; i < len(buf); {
+		// Fast path: pass runs of bytes which don't need any conversion
+		// in one go.
+		if n := plainPrefix(buf[i:]); n > 0 && p.state == preproNormal && len(p.Pending) == 0 {
+			p.normalChunk(buf[i : i+n]...)
+			i += n
+			continue
+		}
+		p.WriteByte(buf[i])
+		i++
 	}
 	return len(buf), nil
 }
 
+// plainPrefix returns length of the longest prefix of buf containing only
+// complete, valid UTF-8 runes other than CR, LF and tab.
+func plainPrefix(buf []byte) int {
+	i := 0
+	for i < len(buf) {
+		b := buf[i]
+		if b < utf8.RuneSelf {
+			if b == _CR || b == _LF || b == '\t' {
+				break
+			}
+			i++
+			continue
+		}
+		// Note: WriteByte treats U+FFFD as invalid UTF-8 too.
+		r, n := utf8.DecodeRune(buf[i:])
+		if r == utf8.RuneError {
+			break
+		}
+		i += n
+	}
+	return i
+}
+
 const (
 	_CR = '\r'
 	_LF = '\n'
@@ -228,7 +260,7 @@ func (p *Preprocessor) calcColumn(added []byte) {
 	i := bytes.LastIndex(added, []byte{_LF})
 	if i >= 0 {
 		p.column = 0
-		added = added[i:]
+		added = added[i+1:]
 	}
 	p.column += utf8.RuneCount(added)
 }
@@ -128,6 +128,11 @@ func TestTabExpansion(test *testing.T) {
 			{ChunkConvertedISO8859_1, bs("\u0080")},
 			{ChunkExpandedTab, bs("   ")},
 		}},
+		{bs("a\n\t"), []Chunk{
+			{ChunkUnchangedRunes, bs("a")},
+			{ChunkUnchangedLF, bs("\n")},
+			{ChunkExpandedTab, bs("    ")},
+		}},
 	}
 	for _, c := range cases {
 		p := Preprocessor{}
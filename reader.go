@@ -0,0 +1,131 @@
+package vfmd
+
+import (
+	"io"
+)
+
+// Reader preprocesses data read from an underlying io.Reader on the fly, so
+// that the whole document doesn't have to be kept in memory. It can be passed
+// directly to mdblock.QuickParse or mdblock.Parse.
+//
+// The preprocessed data can be read either with Read, or line by line with
+// ReadLine, which additionally reports where each line came from in the
+// original input. The two methods shouldn't be mixed.
+type Reader struct {
+	r    io.Reader
+	prep Preprocessor
+	buf  []byte
+	// chunks contains complete Chunks not yet fully returned to the user.
+	chunks []Chunk
+	// pos is the number of bytes of chunks[0] already returned by Read.
+	pos int
+	// offset is the position of chunks[0] in the original input.
+	offset int
+	err    error
+}
+
+// NewReader returns a Reader preprocessing data from r.
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: r}
+}
+
+// Line is a line of preprocessed data, as returned by Reader.ReadLine.
+type Line struct {
+	// Bytes contains the line contents, including the terminating LF
+	// (except for the last line of input, if it doesn't end with one).
+	Bytes []byte
+	// Chunks are the Chunks the line was built from.
+	Chunks []Chunk
+	// Offset is the position of the line's beginning in the original input.
+	Offset int
+}
+
+// Position works like SourceMap.Position for a position in the line.
+func (l Line) Position(byteInLine int) (offset, column int) {
+	m := SourceMap{
+		chunks: l.Chunks,
+		lines:  []sourceLine{{offset: l.Offset, length: len(l.Bytes)}},
+	}
+	return m.Position(0, byteInLine)
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(r.chunks) == 0 {
+			if n > 0 || r.err != nil {
+				break
+			}
+			r.fill()
+			continue
+		}
+		c := r.chunks[0]
+		k := copy(p[n:], c.Bytes[r.pos:])
+		n += k
+		r.pos += k
+		if r.pos == len(c.Bytes) {
+			r.next()
+		}
+	}
+	if n == 0 && r.err != nil {
+		return 0, r.err
+	}
+	return n, nil
+}
+
+// ReadLine returns the next line of preprocessed data. At the end of input,
+// it returns io.EOF.
+func (r *Reader) ReadLine() (Line, error) {
+	line := Line{Offset: r.offset}
+	for {
+		if len(r.chunks) == 0 {
+			if r.err != nil {
+				if len(line.Bytes) > 0 {
+					return line, nil
+				}
+				return Line{}, r.err
+			}
+			r.fill()
+			continue
+		}
+		c := r.chunks[0]
+		r.next()
+		line.Chunks = append(line.Chunks, c)
+		line.Bytes = append(line.Bytes, c.Bytes...)
+		if c.Type == ChunkUnchangedLF {
+			return line, nil
+		}
+	}
+}
+
+func (r *Reader) next() {
+	r.offset += r.chunks[0].SourceLength()
+	r.chunks = r.chunks[1:]
+	r.pos = 0
+}
+
+// fill reads next portion of data from the underlying reader, and moves the
+// complete Chunks from the Preprocessor to r.chunks.
+func (r *Reader) fill() {
+	if r.buf == nil {
+		r.buf = make([]byte, 4096)
+	}
+	n, err := r.r.Read(r.buf)
+	r.prep.Write(r.buf[:n])
+	if err == io.EOF {
+		r.prep.Close()
+	}
+	if err != nil {
+		r.err = err
+	}
+	chunks := r.prep.Chunks
+	if len(chunks) > 0 && err != io.EOF {
+		// The last chunk may still grow, if it contains runes.
+		switch chunks[len(chunks)-1].Type {
+		case ChunkUnchangedRunes, ChunkConvertedISO8859_1:
+			chunks = chunks[:len(chunks)-1]
+		}
+	}
+	r.chunks = append(r.chunks, chunks...)
+	r.prep.Chunks = append(r.prep.Chunks[:0], r.prep.Chunks[len(chunks):]...)
+}
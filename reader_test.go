@@ -0,0 +1,118 @@
+package vfmd
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+)
+
+var readerCases = []string{
+	"",
+	"\xEF\xBB\xBF",
+	"\xEF\xBB\xBFa\tb\r\n\xFFc\r\n\tżx",
+	"plain\nlines\n\nof text\n",
+	"cr\rcr\r",
+	"broken \xC5 rune \xEF\xBF\xBD and żółw\t\n",
+	strings.Repeat("long line ", 1000) + "\n\tend",
+}
+
+// slowPrep preprocesses input byte by byte, without the fast path of Write.
+func slowPrep(input string) Preprocessor {
+	p := Preprocessor{}
+	for _, b := range []byte(input) {
+		p.WriteByte(b)
+	}
+	p.Close()
+	return p
+}
+
+func TestWriteFastPath(test *testing.T) {
+	for _, c := range readerCases {
+		expected := slowPrep(c).Chunks
+		p := Preprocessor{}
+		p.Write([]byte(c))
+		p.Close()
+		if !reflect.DeepEqual(p.Chunks, expected) {
+			test.Errorf("case %q expected %q got %q", c, expected, p.Chunks)
+		}
+	}
+}
+
+func TestReaderRead(test *testing.T) {
+	readers := map[string]func(string) io.Reader{
+		"whole":   func(s string) io.Reader { return strings.NewReader(s) },
+		"onebyte": func(s string) io.Reader { return iotest.OneByteReader(strings.NewReader(s)) },
+		"half":    func(s string) io.Reader { return iotest.HalfReader(strings.NewReader(s)) },
+	}
+	for _, c := range readerCases {
+		p := slowPrep(c)
+		expected := p.bytes()
+		for name, reader := range readers {
+			result, err := ioutil.ReadAll(NewReader(reader(c)))
+			if err != nil {
+				test.Errorf("case %q/%s: %s", c, name, err)
+			}
+			if !bytes.Equal(result, expected) {
+				test.Errorf("case %q/%s expected:\n%q\ngot:\n%q", c, name, expected, result)
+			}
+		}
+	}
+}
+
+func TestReaderReadLine(test *testing.T) {
+	for _, c := range readerCases {
+		p := slowPrep(c)
+		m := p.SourceMap()
+		lines := bytes.SplitAfter(p.bytes(), []byte("\n"))
+		if len(lines[len(lines)-1]) == 0 {
+			lines = lines[:len(lines)-1]
+		}
+		r := NewReader(iotest.OneByteReader(strings.NewReader(c)))
+		for i, expected := range lines {
+			line, err := r.ReadLine()
+			if err != nil {
+				test.Errorf("case %q line %d: %s", c, i, err)
+				break
+			}
+			if !bytes.Equal(line.Bytes, expected) {
+				test.Errorf("case %q line %d expected %q got %q", c, i, expected, line.Bytes)
+			}
+			for b := 0; b <= len(expected); b++ {
+				offset, column := line.Position(b)
+				eoffset, ecolumn := m.Position(i, b)
+				if offset != eoffset || column != ecolumn {
+					test.Errorf("case %q line %d byte %d expected %d, %d got %d, %d",
+						c, i, b, eoffset, ecolumn, offset, column)
+				}
+			}
+		}
+		if line, err := r.ReadLine(); err != io.EOF {
+			test.Errorf("case %q expected EOF, got %q, %v", c, line.Bytes, err)
+		}
+	}
+}
+
+func TestReaderQuickParse(test *testing.T) {
+	input := "# head\r\n\n>\tsome *text*\n\n\t\xFFcode\n"
+	prep, err := QuickPrep(strings.NewReader(input))
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	result, err := mdblock.QuickParse(NewReader(strings.NewReader(input)), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if !reflect.DeepEqual(result, expected) {
+		test.Errorf("expected:\n%v\ngot:\n%v", expected, result)
+	}
+}
@@ -0,0 +1,111 @@
+package vfmd
+
+import (
+	"io"
+	"unicode/utf8"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+)
+
+// QuickPrepMap works like QuickPrep, but additionally returns a SourceMap,
+// which allows to find positions in the original input corresponding to
+// positions in the returned preprocessed data.
+func QuickPrepMap(r io.Reader) ([]byte, SourceMap, error) {
+	prep := Preprocessor{}
+	err := prep.readAll(r)
+	if err != nil {
+		return nil, SourceMap{}, err
+	}
+	return prep.bytes(), prep.SourceMap(), nil
+}
+
+// SourceMap translates positions in data emitted by Preprocessor back to
+// positions in the original input stream.
+type SourceMap struct {
+	chunks []Chunk
+	// lines contains, for every line of the preprocessed data, index of its
+	// first chunk, its offset in the original input, and its length.
+	lines []sourceLine
+}
+
+type sourceLine struct {
+	chunk  int
+	offset int
+	length int // in preprocessed data, including the terminating LF
+}
+
+// SourceMap builds a SourceMap from the Chunks emitted so far.
+func (p *Preprocessor) SourceMap() SourceMap {
+	m := SourceMap{
+		chunks: p.Chunks,
+		lines:  []sourceLine{{}},
+	}
+	offset := 0
+	for i, c := range p.Chunks {
+		offset += c.SourceLength()
+		m.lines[len(m.lines)-1].length += len(c.Bytes)
+		if c.Type == ChunkUnchangedLF {
+			m.lines = append(m.lines, sourceLine{chunk: i + 1, offset: offset})
+		}
+	}
+	return m
+}
+
+// Position converts a position in preprocessed data, given as a line number
+// (starting at 0) and a byte offset in that line, into a byte offset in the
+// original input, and a column (counted in runes, starting at 0) in the
+// original line. A position inside an expanded tab maps to the tab character.
+// An ignored Byte-Order-Mark doesn't count as a column. If line is out of
+// range, Position returns -1, -1.
+func (m SourceMap) Position(line, byteInLine int) (offset, column int) {
+	if line < 0 || line >= len(m.lines) {
+		return -1, -1
+	}
+	l := m.lines[line]
+	offset, pos := l.offset, 0
+	for _, c := range m.chunks[l.chunk:] {
+		n := len(c.Bytes)
+		if byteInLine < pos {
+			byteInLine = pos
+		}
+		if byteInLine < pos+n || c.Type == ChunkUnchangedLF {
+			switch c.Type {
+			case ChunkUnchangedRunes, ChunkConvertedISO8859_1:
+				// Each converted ISO-8859-1 rune comes from a single
+				// source byte.
+				prefix := c.Bytes[:byteInLine-pos]
+				k := utf8.RuneCount(prefix)
+				if c.Type == ChunkUnchangedRunes {
+					return offset + len(prefix), column + k
+				}
+				return offset + k, column + k
+			}
+			return offset, column
+		}
+		pos += n
+		offset += c.SourceLength()
+		switch c.Type {
+		case ChunkUnchangedRunes, ChunkConvertedISO8859_1:
+			column += utf8.RuneCount(c.Bytes)
+		case ChunkExpandedTab, ChunkIgnoredCR:
+			column++
+		}
+	}
+	return offset, column
+}
+
+// RunPosition returns the position in the original input of the beginning of
+// run. It requires that run.Bytes is a subslice of a line allocated with
+// capacity equal to its length, as done by mdblock.QuickParse, so that the
+// byte offset in the line can be calculated from the capacity of run.Bytes.
+// If the position can't be determined, RunPosition returns -1, -1.
+func (m SourceMap) RunPosition(run md.Run) (offset, column int) {
+	if run.Line < 0 || run.Line >= len(m.lines) {
+		return -1, -1
+	}
+	b := m.lines[run.Line].length - cap(run.Bytes)
+	if b < 0 {
+		return -1, -1
+	}
+	return m.Position(run.Line, b)
+}
@@ -0,0 +1,86 @@
+package vfmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+)
+
+func TestSourceMapPosition(test *testing.T) {
+	input := "\xEF\xBB\xBFa\tb\r\n\xFFc\r\n\tżx"
+	cases := []struct {
+		line, byteInLine int
+		offset, column   int
+	}{
+		{0, 0, 3, 0},   // a, after BOM
+		{0, 1, 4, 1},   // tab
+		{0, 3, 4, 1},   // inside expanded tab
+		{0, 4, 5, 2},   // b
+		{0, 5, 7, 4},   // LF, after CR
+		{1, 0, 8, 0},   // \xFF converted to 2 bytes
+		{1, 2, 9, 1},   // c
+		{1, 3, 11, 3},  // LF
+		{2, 0, 12, 0},  // tab
+		{2, 4, 13, 1},  // ż
+		{2, 6, 15, 2},  // x
+		{2, 7, 16, 3},  // end of input
+		{3, 0, -1, -1}, // no such line
+		{-1, 0, -1, -1},
+	}
+	prep, m, err := QuickPrepMap(strings.NewReader(input))
+	if err != nil {
+		test.Fatal(err)
+	}
+	if string(prep) != "a   b\nÿc\n    żx" {
+		test.Fatalf("unexpected preprocessed data %q", prep)
+	}
+	for _, c := range cases {
+		offset, column := m.Position(c.line, c.byteInLine)
+		if offset != c.offset || column != c.column {
+			test.Errorf("case %d:%d expected %d, %d got %d, %d",
+				c.line, c.byteInLine, c.offset, c.column, offset, column)
+		}
+	}
+}
+
+func TestSourceMapRunPosition(test *testing.T) {
+	input := "# head\r\n\n>\tsome *text*"
+	prep, m, err := QuickPrepMap(strings.NewReader(input))
+	if err != nil {
+		test.Fatal(err)
+	}
+	tags, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	var offsets []int
+	for _, t := range tags {
+		if p, ok := t.(md.Prose); ok {
+			offset, _ := m.RunPosition(p[0])
+			offsets = append(offsets, offset)
+		}
+	}
+	expected := []int{2, 11, 17}
+	if !equalInts(offsets, expected) {
+		test.Errorf("expected prose offsets %v got %v", expected, offsets)
+	}
+	offset, column := m.RunPosition(md.Run{Line: -1, Bytes: []byte("head")})
+	if offset != -1 || column != -1 {
+		test.Errorf("expected unknown position, got %d, %d", offset, column)
+	}
+}
+
+func equalInts(a, b []int) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
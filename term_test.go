@@ -0,0 +1,61 @@
+package vfmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdterm"
+)
+
+func TestTerm(test *testing.T) {
+	cases := []struct {
+		input    string
+		r        mdterm.Renderer
+		expected string
+	}{{
+		input:    "# Title\n\nSome *em* and **strong *both***\n",
+		expected: "\x1b[1;4;35mTitle\x1b[0m\n\nSome \x1b[3mem\x1b[0m and \x1b[1mstrong \x1b[3mboth\x1b[0m\x1b[1m\x1b[0m\n",
+	}, {
+		input:    "A [long *linked* text](http://x.org) here\n",
+		r:        mdterm.Renderer{Width: 12},
+		expected: "A \x1b[4;34mlong\x1b[0m\n\x1b[4;34m\x1b[3mlinked\x1b[0m\x1b[4;34m text\x1b[0m\n\x1b[2m(http://x.org)\x1b[0m\nhere\n",
+	}, {
+		input:    "Title\n=====\n\n[a](http://a.org) <http://b.org> `c\x1b[31m`\n",
+		r:        mdterm.Renderer{NoColor: true},
+		expected: "Title\n=====\n\na (http://a.org) http://b.org c�[31m\n",
+	}, {
+		input:    "    code\n      more\n\n> quote\n>\n> * a\n>   * b\n",
+		r:        mdterm.Renderer{NoColor: true},
+		expected: "┌────────┐\n│ code   │\n│   more │\n└────────┘\n\n│ quote\n│\n│ • a\n│   • b\n",
+	}, {
+		input:    "para\n\n- - -\n",
+		r:        mdterm.Renderer{Width: 5, NoColor: true},
+		expected: "para\n\n─────\n",
+	}}
+	for _, c := range cases {
+		prep, _ := QuickPrep(strings.NewReader(c.input))
+		blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+		if err != nil {
+			test.Errorf("case %q parse error: %s", c.input, err)
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		err = c.r.Render(buf, blocks)
+		if err != nil {
+			test.Errorf("case %q render error: %s", c.input, err)
+			continue
+		}
+		if buf.String() != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, buf.String()))
+		}
+	}
+}
+
+func TestTermRenderError(test *testing.T) {
+	testRenderError(test, mdterm.Renderer{}.Render)
+}
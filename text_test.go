@@ -0,0 +1,71 @@
+package vfmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdtext"
+)
+
+func TestText(test *testing.T) {
+	cases := []struct {
+		input    string
+		width    int
+		expected string
+	}{{
+		input:    "Title *here*\n=====\n\nSub\n---\n\n### Third\n",
+		expected: "Title here\n==========\n\nSub\n---\n\nThird\n",
+	}, {
+		input:    "Some *emphasized* and `code` text,\nwith a hard  \nbreak &amp; <b>tags</b>.\n",
+		width:    20,
+		expected: "Some emphasized and\ncode text, with a\nhard\nbreak & tags.\n",
+	}, {
+		input:    "[a](http://a.org) [b][r] [c][none] <http://auto.org> [me@x.org](mailto:me@x.org) ![img](i.png)\n\n[r]: http://r.org\n",
+		expected: "a (http://a.org) b (http://r.org) [c][none] http://auto.org me@x.org img\n",
+	}, {
+		input:    "* a\n* b\n  * c\n\n9. x\n\n   more\n\n10. y\n",
+		expected: "* a\n* b\n  * c\n\n 9. x\n\n    more\n\n10. y\n",
+	}, {
+		input:    "> a quote\n> > nested with words\n",
+		width:    12,
+		expected: "> a quote\n>\n> > nested\n> > with\n> > words\n",
+	}, {
+		input:    "para\n\n    code  *x*\n\n      more\n\n<div>\nhtml\n</div>\n\n* * *\n",
+		width:    10,
+		expected: "para\n\n    code  *x*\n\n      more\n\nhtml\n\n----------\n",
+	}, {
+		input:    "<details>\n<summary>More &amp; less</summary>\n  Hidden   text, <!-- not\n  this --> <i>wrapped</i>\n</details>\n\n<!-- only a comment -->\n\nafter\n",
+		width:    16,
+		expected: "More & less\nHidden text,\nwrapped\n\nafter\n",
+	}, {
+		input:    "averyveryverylongword and short\n",
+		width:    8,
+		expected: "averyveryverylongword\nand\nshort\n",
+	}}
+	for _, c := range cases {
+		prep, _ := QuickPrep(strings.NewReader(c.input))
+		blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+		if err != nil {
+			test.Errorf("case %q parse error: %s", c.input, err)
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		err = mdtext.Renderer{Width: c.width}.Render(buf, blocks)
+		if err != nil {
+			test.Errorf("case %q render error: %s", c.input, err)
+			continue
+		}
+		if buf.String() != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, buf.String()))
+		}
+	}
+}
+
+func TestTextRenderError(test *testing.T) {
+	testRenderError(test, mdtext.Renderer{}.Render)
+}
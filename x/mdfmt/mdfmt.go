@@ -0,0 +1,701 @@
+// Package mdfmt renders md.Tag streams back to Markdown text, in a canonical
+// vfmd style: ATX headers, '*' for emphasis and bullets, sequential numbers in
+// ordered lists, single blank lines between blocks. Parsing the output again
+// gives an equivalent tree.
+package mdfmt
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdtree"
+	"gopkg.in/akavel/vfmd.v1/mdutils"
+)
+
+func QuickRender(w io.Writer, blocks []md.Tag) error {
+	return Renderer{}.Render(w, blocks)
+}
+
+// Renderer writes blocks as canonical vfmd Markdown.
+type Renderer struct {
+	// Original, if not nil, is called for every top-level block whose
+	// canonical rendering is the same as its raw lines (not counting trailing
+	// blank lines). It may return the corresponding part of the original,
+	// not preprocessed input, to be written instead, so that e.g. tabs and
+	// line endings in blocks which didn't need formatting are kept intact.
+	// It is also called for blank lines separating blocks, if their number
+	// doesn't change.
+	Original func(raw md.Raw) []byte
+}
+
+// Render writes blocks to w as Markdown.
+func (r Renderer) Render(w io.Writer, blocks []md.Tag) error {
+	c := Context{Tags: blocks, p: &printer{w: w, bol: true, blanks: 2}}
+	buf := bytes.NewBuffer(nil)
+	if r.Original != nil {
+		c.p.w = buf
+	}
+	var blanks []byte
+	for len(c.Tags) > 0 && c.Err == nil {
+		t := c.Tags[0]
+		c.block()
+		if r.Original != nil && c.Err == nil {
+			c.Err = r.flush(w, buf, t, &blanks)
+		}
+	}
+	return c.Err
+}
+
+// flush writes the rendered block from buf to w, replacing it with the
+// original text if it was not changed. The blank lines separating it from the
+// previous block are replaced with the original ones kept in blanks, if there
+// are as many of them; blanks is then set to the original blank lines ending
+// the block.
+func (r Renderer) flush(w io.Writer, buf *bytes.Buffer, block md.Tag, blanks *[]byte) error {
+	out := buf.Bytes()
+	body := bytes.TrimLeft(out, "\n")
+	var raw md.Raw
+	if b, ok := block.(interface{ GetRaw() md.Region }); ok {
+		raw = md.Raw(b.GetRaw())
+	}
+	n := len(raw)
+	for n > 0 && mdutils.IsBlank(raw[n-1].Bytes) {
+		n--
+	}
+	if len(body) == 0 {
+		// E.g. md.NullBlock, separating the blocks.
+		if n < len(raw) {
+			*blanks = append(*blanks, r.Original(raw[n:])...)
+		}
+		buf.Reset()
+		_, err := w.Write(out)
+		return err
+	}
+	sep := out[:len(out)-len(body)]
+	if len(sep) > 0 && bytes.Count(*blanks, []byte("\n")) == len(sep) && mdutils.IsBlank(*blanks) {
+		sep = *blanks
+	}
+	*blanks = nil
+	if n > 0 && bytes.Equal(body, rawText(raw[:n])) {
+		if orig := r.Original(raw[:n]); orig != nil {
+			body = orig
+			if !bytes.HasSuffix(orig, []byte("\n")) {
+				body = append(orig[:len(orig):len(orig)], '\n')
+			}
+		}
+	}
+	if n < len(raw) {
+		*blanks = append(*blanks, r.Original(raw[n:])...)
+	}
+	buf.Reset()
+	_, err := w.Write(append(sep[:len(sep):len(sep)], body...))
+	return err
+}
+
+// rawText concatenates the raw lines, terminating the last one with LF.
+func rawText(raw md.Raw) []byte {
+	text := []byte{}
+	for _, r := range raw {
+		text = append(text, r.Bytes...)
+	}
+	if !bytes.HasSuffix(text, []byte("\n")) {
+		text = append(text, '\n')
+	}
+	return text
+}
+
+// Spaner is implemented by span tags which are not part of package md, to
+// render themselves as Markdown.
+type Spaner interface {
+	MarkdownSpan(*Context)
+}
+
+// Blocker is implemented by block tags which are not part of package md, to
+// render themselves as Markdown.
+type Blocker interface {
+	MarkdownBlock(*Context)
+}
+
+// Context holds the rendering state. Blocker and Spaner implementations must
+// move Tags past the rendered tag and its md.End{}, and may use the methods
+// of Context for writing the output.
+type Context struct {
+	Tags []md.Tag
+	Err  error
+
+	p *printer
+	// bullet of the unordered list rendered just before the current block,
+	// if any.
+	prevBullet  string
+	prevCode    bool
+	prevOrdered bool
+	prevPara    bool
+}
+
+// Printf writes formatted text to the output, prefixing any new lines with
+// the markers of the containing blocks (like "> ").
+func (c *Context) Printf(format string, args ...interface{}) {
+	c.Write([]byte(fmt.Sprintf(format, args...)))
+}
+
+// Write writes buf to the output, prefixing any new lines with the markers of
+// the containing blocks (like "> ").
+func (c *Context) Write(buf []byte) {
+	if c.Err != nil {
+		return
+	}
+	c.Err = c.p.write(buf)
+}
+
+// Blank requests a blank line to be written before any further output.
+func (c *Context) Blank() { c.blanks(1) }
+
+func (c *Context) blanks(n int) {
+	if c.p.pendingBlanks < n {
+		c.p.pendingBlanks = n
+	}
+}
+
+// Nest renders blocks from c.Tags up to and including the closing md.End{},
+// with each of their lines prefixed with the specified string. The first line
+// is prefixed with first instead.
+func (c *Context) Nest(first, rest string) {
+	pending := c.p.pendingBlanks
+	c.p.push(first, rest)
+	c.Blocks()
+	if c.Err == nil && !c.p.levels[len(c.p.levels)-1].used {
+		// Blank lines requested inside the block are dropped with it.
+		c.p.pendingBlanks = pending
+		c.Err = c.p.emptyBlock()
+	}
+	c.p.pop()
+}
+
+// Blocks renders blocks from c.Tags up to and including the closing md.End{}.
+func (c *Context) Blocks() {
+	for c.Err == nil {
+		if len(c.Tags) == 0 {
+			c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+			return
+		}
+		if (c.Tags[0] == md.End{}) {
+			c.Tags = c.Tags[1:]
+			return
+		}
+		c.block()
+	}
+}
+
+// Skip moves c.Tags past the closing md.End{} of the current level, without
+// rendering anything.
+func (c *Context) Skip() {
+	end := mdtree.EndOf(c.Tags)
+	if end < 0 {
+		c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+		return
+	}
+	c.Tags = c.Tags[end+1:]
+}
+
+// Spans renders spans from c.Tags up to and including the closing md.End{}.
+func (c *Context) Spans() {
+	for c.Err == nil {
+		if len(c.Tags) == 0 {
+			c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+			return
+		}
+		if (c.Tags[0] == md.End{}) {
+			c.Tags = c.Tags[1:]
+			return
+		}
+		c.span()
+	}
+}
+
+func (c *Context) block() {
+	tags := c.Tags
+	t := c.Tags[0]
+	c.Tags = c.Tags[1:]
+	if _, ok := t.(md.NullBlock); ok {
+		if n := len(c.p.levels); n > 0 && !c.p.levels[n-1].used {
+			// A blank line starting a list item or blockquote must be kept
+			// on the marker's line, so that e.g. a loose item stays loose.
+			c.Err = c.p.emptyBlock()
+		} else {
+			c.Blank()
+		}
+		c.Tags = c.Tags[1:]
+		return
+	}
+	prevBullet, prevCode, prevOrdered, prevPara := c.prevBullet, c.prevCode, c.prevOrdered, c.prevPara
+	c.prevBullet, c.prevCode, c.prevOrdered, c.prevPara = "", false, false, false
+	switch t := t.(type) {
+	case md.ParagraphBlock:
+		mark := c.p.written
+		c.Spans()
+		if c.Err == nil && c.p.written == mark {
+			// Text which renders as nothing (like a lone backslash) is
+			// kept as is, so that the block isn't lost.
+			c.raw(t.Raw)
+		} else {
+			c.Printf("\n")
+			c.BlankAfter(t.Raw)
+		}
+		c.prevPara = true
+	case md.AtxHeaderBlock:
+		if prevPara {
+			// Otherwise, the header could be joined with the paragraph.
+			c.Blank()
+		}
+		c.header(t.Level)
+		c.BlankAfter(t.Raw)
+	case md.SetextHeaderBlock:
+		if prevPara {
+			c.Blank()
+		}
+		c.header(t.Level)
+		c.BlankAfter(t.Raw)
+	case md.CodeBlock:
+		if prevCode {
+			// A single blank line would join the blocks.
+			c.blanks(2)
+		}
+		c.p.push("    ", "    ")
+		for _, r := range t.Prose {
+			c.Write(r.Bytes)
+		}
+		if n := len(t.Prose); n > 0 && !bytes.HasSuffix(t.Prose[n-1].Bytes, []byte("\n")) {
+			c.Printf("\n")
+		}
+		c.p.pop()
+		c.Tags = c.Tags[1:]
+		c.BlankAfter(t.Raw)
+		c.prevCode = true
+	case md.QuoteBlock:
+		c.Nest("> ", "> ")
+		c.BlankAfter(t.Raw)
+	case md.HorizontalRuleBlock:
+		c.Printf("* * *\n")
+		c.Tags = c.Tags[1:]
+		c.BlankAfter(t.Raw)
+	case md.UnorderedListBlock:
+		bullet := "* "
+		if prevBullet == bullet {
+			// Keep the adjacent lists separate.
+			bullet = "- "
+		}
+		c.items(func(int) string { return bullet })
+		c.BlankAfter(t.Raw)
+		c.prevBullet = bullet
+	case md.OrderedListBlock:
+		if prevOrdered {
+			// Like with code blocks, a single blank line would join the
+			// lists.
+			c.blanks(2)
+		}
+		start := 1
+		fmt.Sscanf(string(bytes.TrimSpace(t.Starter.Bytes)), "%d", &start)
+		c.items(func(i int) string { return fmt.Sprintf("%d. ", start+i) })
+		c.BlankAfter(t.Raw)
+		c.prevOrdered = true
+	case md.HTMLBlock:
+		c.raw(t.Raw)
+		c.Tags = c.Tags[1:]
+	case md.ReferenceResolutionBlock:
+		c.raw(t.Raw)
+		c.Tags = c.Tags[1:]
+	default:
+		b, ok := t.(Blocker)
+		if !ok {
+			c.Err = md.RenderErrorf(tags, "block type %T not supported, missing MarkdownBlock method", t)
+			return
+		}
+		c.Tags = tags
+		b.MarkdownBlock(c)
+	}
+}
+
+// raw writes the lines of a block verbatim, followed by a blank line if
+// they end with one.
+func (c *Context) raw(raw md.Raw) {
+	n := len(raw)
+	for n > 0 && mdutils.IsBlank(raw[n-1].Bytes) {
+		n--
+	}
+	for _, r := range raw[:n] {
+		c.Write(r.Bytes)
+	}
+	if n > 0 && !bytes.HasSuffix(raw[n-1].Bytes, []byte("\n")) {
+		c.Printf("\n")
+	}
+	c.BlankAfter(raw)
+}
+
+// BlankAfter requests a blank line if the block's raw region ends with one.
+func (c *Context) BlankAfter(raw md.Raw) {
+	if n := len(raw); n > 0 && mdutils.IsBlank(raw[n-1].Bytes) {
+		c.Blank()
+	}
+}
+
+func (c *Context) header(level int) {
+	prefix := strings.Repeat("#", level)
+	c.Printf("%s ", prefix)
+	mark := c.p.written
+	c.Spans()
+	if c.p.lastByte == '#' && c.p.written > mark {
+		// Otherwise, the final '#' would be taken as part of a closing
+		// sequence.
+		c.Printf(" %s", prefix)
+	}
+	c.Printf("\n")
+}
+
+func (c *Context) items(marker func(i int) string) {
+	c.Err = mdtree.Items(&c.Tags, func(i int, item md.ItemBlock) error {
+		m := marker(i)
+		c.Nest(m, strings.Repeat(" ", len(m)))
+		c.BlankAfter(item.Raw)
+		return c.Err
+	})
+}
+
+// printer writes lines prefixed with markers of the containing blocks.
+type printer struct {
+	w      io.Writer
+	levels []level
+	// bol is true at the beginning of a line.
+	bol bool
+	// blanks is the number of blank lines just written; at the beginning of
+	// the output it is big enough not to write any more.
+	blanks        int
+	pendingBlanks int
+	written       int
+	lastByte      byte
+}
+
+type level struct {
+	first, rest string
+	used        bool
+}
+
+func (p *printer) push(first, rest string) {
+	p.levels = append(p.levels, level{first: first, rest: rest})
+}
+
+func (p *printer) pop() {
+	p.levels = p.levels[:len(p.levels)-1]
+}
+
+func (p *printer) write(buf []byte) error {
+	for len(buf) > 0 {
+		chunk := buf
+		if i := bytes.IndexByte(buf, '\n'); i != -1 {
+			chunk = buf[:i+1]
+		}
+		blankLine := p.bol && chunk[len(chunk)-1] == '\n' && mdutils.IsBlank(chunk)
+		if p.bol {
+			err := p.linePrefix(blankLine)
+			if err != nil {
+				return err
+			}
+		}
+		if blankLine {
+			chunk = chunk[len(chunk)-1:]
+		}
+		_, err := p.w.Write(chunk)
+		if err != nil {
+			return err
+		}
+		p.written += len(chunk)
+		p.lastByte = chunk[len(chunk)-1]
+		p.bol = p.lastByte == '\n'
+		if blankLine {
+			p.blanks++
+		} else {
+			p.blanks = 0
+		}
+		buf = buf[len(chunk):]
+	}
+	return nil
+}
+
+// linePrefix writes the markers of the containing blocks at the beginning of
+// a line, preceded by a pending blank line, if any.
+func (p *printer) linePrefix(blankLine bool) error {
+	if !blankLine {
+		err := p.pendingLines()
+		if err != nil {
+			return err
+		}
+	}
+	prefix := p.prefix()
+	if blankLine {
+		prefix = strings.TrimRight(prefix, " ")
+	}
+	_, err := io.WriteString(p.w, prefix)
+	return err
+}
+
+// pendingLines writes the requested blank lines.
+func (p *printer) pendingLines() error {
+	// Only the blocks which already started are continued.
+	prefix := ""
+	for _, l := range p.levels {
+		if !l.used {
+			break
+		}
+		prefix += l.rest
+	}
+	for ; p.blanks < p.pendingBlanks; p.blanks++ {
+		_, err := io.WriteString(p.w, strings.TrimRight(prefix, " ")+"\n")
+		if err != nil {
+			return err
+		}
+	}
+	p.pendingBlanks = 0
+	return nil
+}
+
+// prefix returns the markers of the containing blocks for a new line, and
+// marks the blocks as started.
+func (p *printer) prefix() string {
+	prefix := ""
+	for i := range p.levels {
+		l := &p.levels[i]
+		if l.used {
+			prefix += l.rest
+		} else {
+			prefix += l.first
+			l.used = true
+		}
+	}
+	return prefix
+}
+
+// emptyBlock writes a line with just the markers of the containing blocks,
+// so that an innermost block without any contents (like an empty blockquote
+// or list item) is not lost. The space after a list item marker is kept, as
+// the item wouldn't be detected without it.
+func (p *printer) emptyBlock() error {
+	err := p.pendingLines()
+	if err != nil {
+		return err
+	}
+	quote := strings.TrimSpace(p.levels[len(p.levels)-1].first) == ">"
+	line := p.prefix()
+	if quote {
+		line = strings.TrimRight(line, " ")
+	}
+	line += "\n"
+	_, err = io.WriteString(p.w, line)
+	if err != nil {
+		return err
+	}
+	p.written += len(line)
+	p.lastByte = '\n'
+	p.bol = true
+	p.blanks = 0
+	return nil
+}
+
+func (c *Context) span() {
+	tags := c.Tags
+	t := c.Tags[0]
+	c.Tags = c.Tags[1:]
+	switch t := t.(type) {
+	case md.Prose:
+		c.prose(t)
+	case md.Emphasis:
+		marker := strings.Repeat("*", t.Level)
+		c.Printf("%s", marker)
+		c.Spans()
+		c.Printf("%s", marker)
+	case md.Code:
+		if t.Raw != nil {
+			for _, r := range t.Raw {
+				c.Write(r.Bytes)
+			}
+		} else {
+			c.Printf("%s", codeSpan(t.Code))
+		}
+		c.Tags = c.Tags[1:]
+	case md.Link:
+		c.Printf("[")
+		c.Spans()
+		if t.RawEnd != nil {
+			for _, r := range t.RawEnd {
+				c.Write(r.Bytes)
+			}
+		} else {
+			c.Printf("%s", linkEnd(t.ReferenceID, t.URL, t.Title))
+		}
+	case md.Image:
+		c.Printf("![%s", altEscaper.Replace(t.AltText))
+		if t.RawEnd != nil {
+			for _, r := range t.RawEnd {
+				c.Write(r.Bytes)
+			}
+		} else {
+			c.Printf("%s", linkEnd(t.ReferenceID, t.URL, t.Title))
+		}
+		c.Tags = c.Tags[1:]
+	case md.AutomaticLink:
+		c.Printf("<%s>", t.Text)
+		c.Tags = c.Tags[1:]
+	case md.HTMLTag:
+		c.Write(t.HTML)
+		c.Tags = c.Tags[1:]
+	case md.Entity:
+		c.Write(t.Text)
+		c.Tags = c.Tags[1:]
+	case md.HardBreak:
+		c.Printf("  \n")
+		c.Tags = c.Tags[1:]
+	default:
+		s, ok := t.(Spaner)
+		if !ok {
+			c.Err = md.RenderErrorf(tags, "span type %T not supported, missing MarkdownSpan method", t)
+			return
+		}
+		c.Tags = tags
+		s.MarkdownSpan(c)
+	}
+}
+
+// prose writes text, restoring backslash escapes found in the source, and
+// escaping any other characters which could be taken for span markers (see
+// escapeSpans), and characters at the beginning of a line which could be taken
+// for block markers.
+func (c *Context) prose(p md.Prose) {
+	for i, r := range p {
+		buf := r.Bytes
+		if i > 0 && len(buf) > 0 && escaped(p[i-1], r) {
+			c.Printf(`\`)
+			c.Write(buf[:1])
+			buf = buf[1:]
+		}
+		for len(buf) > 0 && c.Err == nil {
+			line := buf
+			if j := bytes.IndexByte(buf, '\n'); j != -1 {
+				line = buf[:j+1]
+			}
+			buf = buf[len(line):]
+			line = escapeSpans(line)
+			if c.p.bol {
+				line = escapeLineStart(line)
+			}
+			c.Write(line)
+		}
+	}
+}
+
+// escapeSpans returns text with a backslash inserted before each character
+// which could start or end a span: emphasis markers (except underscores
+// inside a word), backticks, brackets, and '<' or '&' which could start an
+// HTML tag, automatic link or entity.
+func escapeSpans(text []byte) []byte {
+	buf := make([]byte, 0, len(text))
+	for i := 0; i < len(text); i++ {
+		c := text[i]
+		switch {
+		case c == '_':
+			j := i
+			for j < len(text) && text[j] == '_' {
+				j++
+			}
+			if i > 0 && j < len(text) && isAlnum(text[i-1]) && isAlnum(text[j]) {
+				buf = append(buf, text[i:j]...)
+			} else {
+				buf = append(buf, bytes.Repeat([]byte(`\_`), j-i)...)
+			}
+			i = j - 1
+			continue
+		case strings.IndexByte("*`[]", c) != -1,
+			c == '<' && (i+1 == len(text) || !unicode.IsSpace(rune(text[i+1]))),
+			c == '&' && (i+1 == len(text) || text[i+1] == '#' || isAlnum(text[i+1])):
+			buf = append(buf, '\\')
+		}
+		buf = append(buf, c)
+	}
+	return buf
+}
+
+func isAlnum(c byte) bool {
+	return 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9'
+}
+
+// reLineStart matches the beginning of a line of text, up to a character
+// which would make it an ATX header, blockquote, list item, horizontal rule or
+// setext header underline.
+var reLineStart = regexp.MustCompile(`^ *([#>]|[-+]([ \n]|$)|[-=_][-=_ ]*\n?$|[0-9]+(\.)([ \n]|$))`)
+
+// escapeLineStart returns line with a backslash inserted before the character
+// which could be taken for a block marker, if any.
+func escapeLineStart(line []byte) []byte {
+	m := reLineStart.FindSubmatchIndex(line)
+	if m == nil {
+		return line
+	}
+	i := m[2]
+	if m[6] != -1 {
+		// Escape the dot of an ordered list marker.
+		i = m[6]
+	}
+	return append(append(append([]byte{}, line[:i]...), '\\'), line[i:]...)
+}
+
+// escaped reports if a backslash was dropped between the runs, i.e. if cur
+// starts with a character escaped in the source.
+func escaped(prev, cur md.Run) bool {
+	if prev.Line != cur.Line || cap(prev.Bytes) == 0 || cap(cur.Bytes) == 0 {
+		return false
+	}
+	a, b := prev.Bytes[:cap(prev.Bytes)], cur.Bytes[:cap(cur.Bytes)]
+	if &a[len(a)-1] != &b[len(b)-1] {
+		// Not the same line.
+		return false
+	}
+	return cap(prev.Bytes)-len(prev.Bytes) == cap(cur.Bytes)+1
+}
+
+var altEscaper = strings.NewReplacer(`\`, `\\`, `[`, `\[`, `]`, `\]`)
+
+// codeSpan returns code enclosed in enough backticks.
+func codeSpan(code []byte) string {
+	n, longest := 0, 0
+	for _, b := range code {
+		if b == '`' {
+			n++
+			if n > longest {
+				longest = n
+			}
+		} else {
+			n = 0
+		}
+	}
+	fence := strings.Repeat("`", longest+1)
+	if bytes.HasPrefix(code, []byte("`")) || bytes.HasSuffix(code, []byte("`")) {
+		return fence + " " + string(code) + " " + fence
+	}
+	return fence + string(code) + fence
+}
+
+// linkEnd returns the part of a link or image following its text.
+func linkEnd(refID, url, title string) string {
+	if url == "" {
+		return "][" + altEscaper.Replace(refID) + "]"
+	}
+	if strings.ContainsAny(url, " ()<>") {
+		url = "<" + url + ">"
+	}
+	if title == "" {
+		return "](" + url + ")"
+	}
+	return "](" + url + ` "` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(title) + `")`
+}
@@ -2,25 +2,51 @@ package mdgithub
 
 import (
 	"bytes"
-	"html"
+	"regexp"
+	"strings"
 
 	"gopkg.in/akavel/vfmd.v1/md"
 	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/mdutils"
+	"gopkg.in/akavel/vfmd.v1/x/mdfmt"
 	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+	"gopkg.in/akavel/vfmd.v1/x/mdterm"
+	"gopkg.in/akavel/vfmd.v1/x/mdtext"
 )
 
 type FencedCodeBlock struct {
-	// TODO(akavel): Language string
+	// Language is the first word of the info string following the opening
+	// fence, e.g. "go" for "```go".
+	Language string
+	// Attributes contains the rest of the info string, after Language.
+	Attributes string
 	md.Prose
 	md.Raw
 }
 
+var (
+	reOpeningFence = regexp.MustCompile("^( {0,3})(```+|~~~+)([^\n]*)\n?$")
+	reClosingFence = regexp.MustCompile("^ {0,3}(```+|~~~+) *\n?$")
+)
+
 func (FencedCodeBlock) Detect(first, second mdblock.Line, detectors mdblock.Detectors) mdblock.Handler {
-	if !bytes.HasPrefix(first.Bytes, []byte("```")) {
+	m := reOpeningFence.FindSubmatch(first.Bytes)
+	if m == nil {
+		return nil
+	}
+	indent, fence, info := len(m[1]), m[2], bytes.Trim(m[3], mdutils.Whites)
+	if fence[0] == '`' && bytes.IndexByte(info, '`') != -1 {
 		return nil
 	}
 
 	block := FencedCodeBlock{}
+	if len(info) > 0 {
+		words := strings.SplitN(string(info), " ", 2)
+		block.Language = mdutils.DeEscape(words[0])
+		if len(words) > 1 {
+			block.Attributes = strings.TrimSpace(words[1])
+		}
+	}
 	done := false
 	return mdblock.HandlerFunc(func(next mdblock.Line, ctx mdblock.Context) (bool, error) {
 		if done {
@@ -32,27 +58,78 @@ func (FencedCodeBlock) Detect(first, second mdblock.Line, detectors mdblock.Dete
 			return false, nil
 		}
 		if len(block.Raw) > 0 {
-			// Three backticks, on second on later line - means end of block.
-			if bytes.HasPrefix(next.Bytes, []byte("```")) {
+			if isClosingFence(next.Bytes, fence) {
 				done = true
+				block.Raw = append(block.Raw, md.Run(next))
 				ctx.Emit(block)
 				ctx.Emit(md.End{})
-			} else {
-				// Collect all stuff between first and last fenced line into Prose.
-				block.Prose = append(block.Prose, md.Run(next))
+				return true, nil
 			}
+			// Collect all stuff between first and last fenced line into Prose,
+			// with the opening fence's indentation removed.
+			block.Prose = append(block.Prose, md.Run{
+				Line:  next.Line,
+				Bytes: trimLeftSpaces(next.Bytes, indent),
+			})
 		}
 		block.Raw = append(block.Raw, md.Run(next))
 		return true, nil
 	})
 }
 
+// isClosingFence reports if line closes a block opened with the specified
+// fence: it must use the same character, and be at least as long.
+func isClosingFence(line, fence []byte) bool {
+	m := reClosingFence.FindSubmatch(line)
+	return m != nil && m[1][0] == fence[0] && len(m[1]) >= len(fence)
+}
+
+func trimLeftSpaces(line []byte, nmax int) []byte {
+	for nmax > 0 && len(line) > 0 && line[0] == ' ' {
+		nmax--
+		line = line[1:]
+	}
+	return line
+}
+
 func (b FencedCodeBlock) HTMLBlock(ctx mdhtml.Context, opt mdhtml.Opt) ([]md.Tag, error) {
-	ctx.Printf("<pre><code>")
+	ctx.CodeBlock(b.Language, b.Prose, opt)
+	// Skip self and subsequent md.End{}
+	return ctx.Tags[2:], ctx.Err
+}
+
+func (b FencedCodeBlock) MarkdownBlock(ctx *mdfmt.Context) {
+	fence := "```"
 	for _, r := range b.Prose {
-		ctx.Printf("%s", html.EscapeString(string(r.Bytes)))
+		for bytes.HasPrefix(bytes.TrimLeft(r.Bytes, " "), []byte(fence)) {
+			fence += "`"
+		}
 	}
-	ctx.Printf("</code></pre>\n")
+	info := b.Language
+	if b.Attributes != "" {
+		info += " " + b.Attributes
+	}
+	ctx.Printf("%s%s\n", fence, info)
+	for _, r := range b.Prose {
+		ctx.Write(r.Bytes)
+	}
+	if n := len(b.Prose); n > 0 && !bytes.HasSuffix(b.Prose[n-1].Bytes, []byte("\n")) {
+		ctx.Printf("\n")
+	}
+	ctx.Printf("%s\n", fence)
+	ctx.BlankAfter(b.Raw)
 	// Skip self and subsequent md.End{}
-	return ctx.Tags[2:], ctx.Err
+	ctx.Tags = ctx.Tags[2:]
+}
+
+func (b FencedCodeBlock) TextBlock(ctx *mdtext.Context) []string {
+	// Skip self and subsequent md.End{}
+	ctx.Tags = ctx.Tags[2:]
+	return mdtext.Verbatim(b.Prose)
+}
+
+func (b FencedCodeBlock) TermBlock(ctx *mdterm.Context) []string {
+	// Skip self and subsequent md.End{}
+	ctx.Tags = ctx.Tags[2:]
+	return mdterm.Box(mdterm.Code(b.Prose))
 }
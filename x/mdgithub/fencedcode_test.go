@@ -0,0 +1,50 @@
+package mdgithub
+
+import (
+	"testing"
+
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+)
+
+func TestFencedCodeHTML(test *testing.T) {
+	cases := []struct {
+		input, expected string
+	}{{
+		"```go\nx := 1\n```\n",
+		"<pre><code class=\"language-go\">x := 1\n</code></pre>\n",
+	}, {
+		"~~~~ c++ linenos=1\n~~~\n```\n~~~~~\n",
+		"<pre><code class=\"language-c++\">~~~\n```\n</code></pre>\n",
+	}, {
+		"  ```\n    a\n   b\n c\n  ```\n",
+		"<pre><code>  a\n b\nc\n</code></pre>\n",
+	}, {
+		"``` a`b\n```\n",
+		"<p><code>a`b</code></p>\n",
+	}, {
+		"```\nunclosed <\n",
+		"<pre><code>unclosed &lt;\n</code></pre>\n",
+	}}
+	detectors := append(mdblock.Detectors{FencedCodeBlock{}}, mdblock.DefaultDetectors...)
+	for _, c := range cases {
+		checkHTML(test, c.input, c.expected, detectors, mdhtml.Renderer{})
+	}
+}
+
+func TestFencedCodeHighlight(test *testing.T) {
+	var language string
+	r := mdhtml.Renderer{
+		Highlight: func(lang string, code []byte) ([]byte, bool) {
+			language = lang
+			return []byte("<i>" + string(code) + "</i>"), true
+		},
+	}
+	input := "```rust\nfn main() {}\n```\n"
+	expected := "<pre><code class=\"language-rust\"><i>fn main() {}\n</i></code></pre>\n"
+	detectors := append(mdblock.Detectors{FencedCodeBlock{}}, mdblock.DefaultDetectors...)
+	checkHTML(test, input, expected, detectors, r)
+	if language != "rust" {
+		test.Errorf("expected language %q passed to highlighter, got %q", "rust", language)
+	}
+}
@@ -0,0 +1,109 @@
+package mdgithub
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/mdspan"
+	"gopkg.in/akavel/vfmd.v1/x/mdfmt"
+	"gopkg.in/akavel/vfmd.v1/x/mdterm"
+	"gopkg.in/akavel/vfmd.v1/x/mdtext"
+)
+
+func TestMarkdown(test *testing.T) {
+	cases := []struct {
+		input, expected string
+	}{{
+		"a ~~b *c*~~ d\n",
+		"a ~~b *c*~~ d\n",
+	}, {
+		"~~~ go  x=1\ncode\n  ```\n~~~~\n\nafter\n",
+		"````go x=1\ncode\n  ```\n````\n\nafter\n",
+	}, {
+		"* ```\n  in list\n  ```\n",
+		"* ```\n  in list\n  ```\n",
+	}, {
+		"a|b|c\n---|:-:|--:\n`x\\|y` | *z* |\n\npara\n",
+		"| a | b | c |\n| --- | :-: | --: |\n| `x\\|y` | *z* |  |\n\npara\n",
+	}}
+	blocks := append(mdblock.Detectors{FencedCodeBlock{}, Table{}}, mdblock.DefaultDetectors...)
+	spans := append([]mdspan.Detector{StrikeThrough{}}, mdspan.DefaultDetectors...)
+	for _, c := range cases {
+		prep, _ := vfmd.QuickPrep(bytes.NewReader([]byte(c.input)))
+		tags, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, blocks, spans)
+		if err != nil {
+			test.Errorf("case %q parse error: %s", c.input, err)
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		err = mdfmt.QuickRender(buf, tags)
+		if err != nil {
+			test.Errorf("case %q render error: %s", c.input, err)
+			continue
+		}
+		if buf.String() != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, buf.String()))
+		}
+	}
+}
+
+func TestText(test *testing.T) {
+	cases := []struct {
+		input, expected string
+	}{{
+		"a ~~b *c*~~ d\n",
+		"a b c d\n",
+	}, {
+		"```go\nfunc  f() {}\n```\n",
+		"    func  f() {}\n",
+	}, {
+		"a|bb|c\n:-:|--:|---\nxyz | *z* | 1\n",
+		" a  | bb | c\n----+----+--\nxyz |  z | 1\n",
+	}}
+	blocks := append(mdblock.Detectors{FencedCodeBlock{}, Table{}}, mdblock.DefaultDetectors...)
+	spans := append([]mdspan.Detector{StrikeThrough{}}, mdspan.DefaultDetectors...)
+	for _, c := range cases {
+		prep, _ := vfmd.QuickPrep(bytes.NewReader([]byte(c.input)))
+		tags, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, blocks, spans)
+		if err != nil {
+			test.Errorf("case %q parse error: %s", c.input, err)
+			continue
+		}
+		buf := bytes.NewBuffer(nil)
+		err = mdtext.QuickRender(buf, tags)
+		if err != nil {
+			test.Errorf("case %q render error: %s", c.input, err)
+			continue
+		}
+		if buf.String() != c.expected {
+			test.Errorf("case %q expected vs. got DIFF:\n%s",
+				c.input, diff.Diff(c.expected, buf.String()))
+		}
+	}
+}
+
+func TestTerm(test *testing.T) {
+	input := "a ~~b~~ c\n\n```\nx\n```\n\na|b\n--|--:\n*1*|22\n"
+	expected := "a \x1b[9mb\x1b[0m c\n\n┌───┐\n│ x │\n└───┘\n\n" +
+		"a |  b\n──┼───\n\x1b[3m1\x1b[0m | 22\n"
+	blocks := append(mdblock.Detectors{FencedCodeBlock{}, Table{}}, mdblock.DefaultDetectors...)
+	spans := append([]mdspan.Detector{StrikeThrough{}}, mdspan.DefaultDetectors...)
+	prep, _ := vfmd.QuickPrep(bytes.NewReader([]byte(input)))
+	tags, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, blocks, spans)
+	if err != nil {
+		test.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = mdterm.QuickRender(buf, tags)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if buf.String() != expected {
+		test.Errorf("expected vs. got DIFF:\n%s", diff.Diff(expected, buf.String()))
+	}
+}
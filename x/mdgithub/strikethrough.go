@@ -11,7 +11,10 @@ import (
 
 	"gopkg.in/akavel/vfmd.v1/md"
 	"gopkg.in/akavel/vfmd.v1/mdspan"
+	"gopkg.in/akavel/vfmd.v1/x/mdfmt"
 	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+	"gopkg.in/akavel/vfmd.v1/x/mdterm"
+	"gopkg.in/akavel/vfmd.v1/x/mdtext"
 )
 
 type StrikeThrough struct{}
@@ -34,7 +37,7 @@ func (StrikeThrough) Detect(ctx *mdspan.Context) (consumed int) {
 			return 0
 		}
 	}
-	if ctx.Pos == len(ctx.Buf)-1 {
+	if ctx.Pos+2 >= len(ctx.Buf) {
 		rightEdge = true
 	} else {
 		// TODO(akavel): decode full rune
@@ -73,3 +76,21 @@ func (s StrikeThrough) HTMLSpan(ctx mdhtml.Context, opt mdhtml.Opt) ([]md.Tag, e
 	ctx.Printf("</del>")
 	return ctx.Tags, ctx.Err
 }
+
+func (s StrikeThrough) MarkdownSpan(ctx *mdfmt.Context) {
+	ctx.Printf("~~")
+	ctx.Tags = ctx.Tags[1:]
+	ctx.Spans()
+	ctx.Printf("~~")
+}
+
+func (s StrikeThrough) TextSpan(ctx *mdtext.Context) string {
+	ctx.Tags = ctx.Tags[1:]
+	return ctx.Spans()
+}
+
+func (s StrikeThrough) TermSpan(ctx *mdterm.Context) string {
+	ctx.Tags = ctx.Tags[1:]
+	// SGR 9 is "crossed-out".
+	return ctx.Styled("9", ctx.Spans)
+}
@@ -0,0 +1,388 @@
+package mdgithub
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/mdspan"
+	"gopkg.in/akavel/vfmd.v1/mdutils"
+	"gopkg.in/akavel/vfmd.v1/x/mdfmt"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+	"gopkg.in/akavel/vfmd.v1/x/mdterm"
+	"gopkg.in/akavel/vfmd.v1/x/mdtext"
+)
+
+// Alignment of a table column, as specified in the delimiter row.
+type Alignment int
+
+const (
+	AlignNone   Alignment = iota // ---
+	AlignLeft                    // :--
+	AlignCenter                  // :-:
+	AlignRight                   // --:
+)
+
+// Table is a block of GitHub-flavored Markdown table. It is followed by
+// TableRow tags (the first one being the header row), then md.End{}. Each
+// TableRow contains TableCell tags and is closed by md.End{}. Each TableCell
+// contains span tags and is closed by md.End{}.
+//
+// The zero value of Table can be used as a block detector.
+type Table struct {
+	// Align contains alignment for every column of the table.
+	Align []Alignment
+	md.Raw
+}
+
+type TableRow struct {
+	Header bool
+	md.Raw
+}
+
+type TableCell struct {
+	Header bool
+	Align  Alignment
+	// Raw contains the cell contents, without surrounding whitespace and
+	// pipes. Backslashes escaping pipes are skipped.
+	md.Raw
+}
+
+var reTableDelimiter = regexp.MustCompile(`^ *(:?)-+(:?) *$`)
+
+func (Table) Detect(first, second mdblock.Line, detectors mdblock.Detectors) mdblock.Handler {
+	if second.EOF() || bytes.HasPrefix(first.Bytes, []byte("    ")) {
+		return nil
+	}
+	header := splitTableRow(first.Bytes)
+	align := tableDelimiters(second.Bytes)
+	if align == nil || len(header) != len(align) {
+		return nil
+	}
+	if !bytes.Contains(first.Bytes, []byte("|")) && !bytes.Contains(second.Bytes, []byte("|")) {
+		return nil
+	}
+
+	block := Table{Align: align}
+	return mdblock.HandlerFunc(func(next mdblock.Line, ctx mdblock.Context) (bool, error) {
+		if next.EOF() || (len(block.Raw) >= 2 && (mdutils.IsBlank(next.Bytes) || startsBlock(next, detectors))) {
+			emitTable(block, ctx)
+			return false, nil
+		}
+		block.Raw = append(block.Raw, md.Run(next))
+		return true, nil
+	})
+}
+
+// startsBlock reports whether line starts a block other than a paragraph,
+// which ends a table, like in GitHub-flavored Markdown.
+func startsBlock(line mdblock.Line, detectors mdblock.Detectors) bool {
+	if bytes.HasPrefix(line.Bytes, []byte("    ")) {
+		// An indented code block can't interrupt a table.
+		return false
+	}
+	for _, d := range detectors {
+		switch d.(type) {
+		case mdblock.ParagraphDetector, Table:
+			continue
+		}
+		if d.Detect(line, mdblock.Line{}, detectors) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+func emitTable(block Table, ctx mdblock.Context) {
+	ctx.Emit(block)
+	if ctx.GetMode() != mdblock.TopBlocks {
+		for i, line := range block.Raw {
+			if i == 1 {
+				// delimiter row
+				continue
+			}
+			emitTableRow(line, i == 0, block.Align, ctx)
+		}
+	}
+	ctx.Emit(md.End{})
+}
+
+func emitTableRow(line md.Run, header bool, align []Alignment, ctx mdblock.Context) {
+	ctx.Emit(TableRow{Header: header, Raw: md.Raw{line}})
+	cells := splitTableRow(line.Bytes)
+	for i, a := range align {
+		cell := TableCell{Header: header, Align: a}
+		if i < len(cells) {
+			cell.Raw = unescapePipes(line.Line, cells[i])
+		}
+		ctx.Emit(cell)
+		if ctx.GetMode() == mdblock.BlocksAndSpans && len(cell.Raw) > 0 {
+			for _, span := range mdspan.ParseRegionLimits(md.Region(cell.Raw), ctx.GetSpanDetectors(), mdblock.LimitsOf(ctx).Spans) {
+				ctx.Emit(span)
+			}
+		}
+		ctx.Emit(md.End{})
+	}
+	ctx.Emit(md.End{})
+}
+
+// splitTableRow splits line into cells on unescaped pipes, dropping
+// the optional leading and trailing pipe. Whitespace around cells is trimmed.
+func splitTableRow(line []byte) [][]byte {
+	line = bytes.Trim(line, mdutils.Whites)
+	line = bytes.TrimPrefix(line, []byte("|"))
+	cells := [][]byte{}
+	start := 0
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '\\':
+			i++
+		case '|':
+			cells = append(cells, bytes.Trim(line[start:i], mdutils.Whites))
+			start = i + 1
+		}
+	}
+	if start < len(line) || len(cells) == 0 {
+		cells = append(cells, bytes.Trim(line[start:], mdutils.Whites))
+	}
+	return cells
+}
+
+// tableDelimiters parses the delimiter row of a table, returning nil if the
+// line is not a valid delimiter row.
+func tableDelimiters(line []byte) []Alignment {
+	if bytes.HasPrefix(line, []byte("    ")) {
+		return nil
+	}
+	var align []Alignment
+	for _, cell := range splitTableRow(line) {
+		m := reTableDelimiter.FindSubmatchIndex(cell)
+		if m == nil {
+			return nil
+		}
+		left, right := m[3] > m[2], m[5] > m[4]
+		switch {
+		case left && right:
+			align = append(align, AlignCenter)
+		case left:
+			align = append(align, AlignLeft)
+		case right:
+			align = append(align, AlignRight)
+		default:
+			align = append(align, AlignNone)
+		}
+	}
+	return align
+}
+
+// unescapePipes splits cell into runs, skipping backslashes which escape
+// pipes, so that `\|` is rendered as `|` even inside code spans.
+func unescapePipes(line int, cell []byte) md.Raw {
+	raw := md.Raw{}
+	start := 0
+	for i := 0; i < len(cell)-1; i++ {
+		if cell[i] != '\\' {
+			continue
+		}
+		if cell[i+1] == '|' {
+			if i > start {
+				raw = append(raw, md.Run{Line: line, Bytes: cell[start:i]})
+			}
+			start = i + 1
+		}
+		i++
+	}
+	if start < len(cell) {
+		raw = append(raw, md.Run{Line: line, Bytes: cell[start:]})
+	}
+	return raw
+}
+
+var htmlAlign = map[Alignment]string{
+	AlignLeft:   ` align="left"`,
+	AlignCenter: ` align="center"`,
+	AlignRight:  ` align="right"`,
+}
+
+func (t Table) HTMLBlock(ctx mdhtml.Context, opt mdhtml.Opt) ([]md.Tag, error) {
+	ctx.Printf("<table>\n")
+	ctx.Tags = ctx.Tags[1:]
+	tbody := false
+	for i := 0; ctx.Err == nil; i++ {
+		row, ok := ctx.Tags[0].(TableRow)
+		if !ok {
+			break
+		}
+		switch {
+		case row.Header:
+			ctx.Printf("<thead>\n")
+		case i == 1:
+			ctx.Printf("<tbody>\n")
+			tbody = true
+		}
+		ctx.Printf("<tr>\n")
+		ctx.Tags = ctx.Tags[1:]
+		for ctx.Err == nil {
+			cell, ok := ctx.Tags[0].(TableCell)
+			if !ok {
+				break
+			}
+			elem := "td"
+			if cell.Header {
+				elem = "th"
+			}
+			ctx.Printf("<%s%s>", elem, htmlAlign[cell.Align])
+			ctx.Spans(ctx.Tags[1:], opt)
+			ctx.Printf("</%s>\n", elem)
+		}
+		ctx.Printf("</tr>\n")
+		if row.Header {
+			ctx.Printf("</thead>\n")
+		}
+		// Skip md.End{} of the row.
+		ctx.Tags = ctx.Tags[1:]
+	}
+	if tbody {
+		ctx.Printf("</tbody>\n")
+	}
+	ctx.Printf("</table>\n")
+	// Skip md.End{} of the table.
+	return ctx.Tags[1:], ctx.Err
+}
+
+var mdAlign = map[Alignment]string{
+	AlignNone:   " --- |",
+	AlignLeft:   " :-- |",
+	AlignCenter: " :-: |",
+	AlignRight:  " --: |",
+}
+
+func (t Table) MarkdownBlock(ctx *mdfmt.Context) {
+	ctx.Tags = ctx.Tags[1:]
+	for i := 0; ctx.Err == nil; i++ {
+		if _, ok := ctx.Tags[0].(TableRow); !ok {
+			break
+		}
+		ctx.Printf("|")
+		ctx.Tags = ctx.Tags[1:]
+		for ctx.Err == nil {
+			cell, ok := ctx.Tags[0].(TableCell)
+			if !ok {
+				break
+			}
+			// Cell contents are split at pipes escaped with backslash.
+			text := [][]byte{}
+			for _, r := range cell.Raw {
+				text = append(text, r.Bytes)
+			}
+			ctx.Printf(" %s |", bytes.Join(text, []byte(`\`)))
+			ctx.Tags = ctx.Tags[1:]
+			ctx.Skip()
+		}
+		ctx.Printf("\n")
+		if i == 0 {
+			ctx.Printf("|")
+			for _, a := range t.Align {
+				ctx.Printf("%s", mdAlign[a])
+			}
+			ctx.Printf("\n")
+		}
+		// Skip md.End{} of the row.
+		ctx.Tags = ctx.Tags[1:]
+	}
+	// Skip md.End{} of the table.
+	ctx.Tags = ctx.Tags[1:]
+	ctx.BlankAfter(t.Raw)
+}
+
+// TextBlock renders the table with columns padded to equal widths, and the
+// header row underlined.
+func (t Table) TextBlock(ctx *mdtext.Context) []string {
+	rows := tableCells(&ctx.Tags, func() string {
+		return strings.Join(strings.Fields(ctx.Spans()), " ")
+	})
+	return t.textLines(rows, utf8.RuneCountInString, "-", "-+-")
+}
+
+// TermBlock renders the table like TextBlock, using box-drawing characters
+// for the header underline.
+func (t Table) TermBlock(ctx *mdterm.Context) []string {
+	rows := tableCells(&ctx.Tags, func() string {
+		return strings.Join(strings.Fields(ctx.Spans()), " ")
+	})
+	return t.textLines(rows, mdterm.Width, "─", "─┼─")
+}
+
+// tableCells moves *tags past a table, returning its cells rendered with
+// the cell function. The function must move *tags past the cell contents and
+// the closing md.End{}.
+func tableCells(tags *[]md.Tag, cell func() string) [][]string {
+	var rows [][]string
+	next := func() md.Tag {
+		if len(*tags) == 0 {
+			return nil
+		}
+		t := (*tags)[0]
+		*tags = (*tags)[1:]
+		return t
+	}
+	next()
+	for {
+		if _, ok := next().(TableRow); !ok {
+			// md.End{} of the table.
+			break
+		}
+		var row []string
+		for {
+			if _, ok := next().(TableCell); !ok {
+				// md.End{} of the row.
+				break
+			}
+			row = append(row, cell())
+		}
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// textLines pads the cells of rows to equal column widths, as measured by
+// the width function, and joins them with " | ". The header row is followed
+// by a line of dash characters, joined with cross.
+func (t Table) textLines(rows [][]string, width func(string) int, dash, cross string) []string {
+	widths := make([]int, len(t.Align))
+	for _, row := range rows {
+		for i, cell := range row {
+			if n := width(cell); n > widths[i] {
+				widths[i] = n
+			}
+		}
+	}
+	var lines []string
+	for r, row := range rows {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			pad := widths[i] - width(cell)
+			switch t.Align[i] {
+			case AlignRight:
+				cell = strings.Repeat(" ", pad) + cell
+			case AlignCenter:
+				cell = strings.Repeat(" ", pad/2) + cell + strings.Repeat(" ", pad-pad/2)
+			default:
+				cell += strings.Repeat(" ", pad)
+			}
+			cells[i] = cell
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, " | "), " "))
+		if r == 0 {
+			dashes := make([]string, len(widths))
+			for i, w := range widths {
+				dashes[i] = strings.Repeat(dash, w)
+			}
+			lines = append(lines, strings.Join(dashes, cross))
+		}
+	}
+	return lines
+}
@@ -0,0 +1,93 @@
+package mdgithub
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+)
+
+func TestTableHTML(test *testing.T) {
+	cases := []struct {
+		input, expected string
+	}{{
+		"a | b\n--- | :-:\n1 | *2*\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th align=\"center\">b</th>\n</tr>\n</thead>\n" +
+			"<tbody>\n<tr>\n<td>1</td>\n<td align=\"center\"><em>2</em></td>\n</tr>\n</tbody>\n</table>\n",
+	}, {
+		"| x | y | z |\n|:--|--:|---|\n| `a\\|b` | \\| |\n| 1 | 2 | 3 | 4 |\n\npara\n",
+		"<table>\n<thead>\n<tr>\n<th align=\"left\">x</th>\n<th align=\"right\">y</th>\n<th>z</th>\n</tr>\n</thead>\n" +
+			"<tbody>\n<tr>\n<td align=\"left\"><code>a|b</code></td>\n<td align=\"right\">|</td>\n<td></td>\n</tr>\n" +
+			"<tr>\n<td align=\"left\">1</td>\n<td align=\"right\">2</td>\n<td>3</td>\n</tr>\n</tbody>\n</table>\n" +
+			"\n<p>para</p>\n",
+	}, {
+		"| only |\n| ---- |\n",
+		"<table>\n<thead>\n<tr>\n<th>only</th>\n</tr>\n</thead>\n</table>\n",
+	}, {
+		"not\n---\n",
+		"<h2>not</h2>\n",
+	}, {
+		"a | b\n--- | --- | ---\n",
+		"<p>a | b\n--- | --- | ---</p>\n",
+	}, {
+		"a | b\n--|--\n1 | 2\nno pipe\n# h\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n" +
+			"<tbody>\n<tr>\n<td>1</td>\n<td>2</td>\n</tr>\n<tr>\n<td>no pipe</td>\n<td></td>\n</tr>\n</tbody>\n</table>\n" +
+			"<h1>h</h1>\n",
+	}, {
+		"a | b\n--|--\n> q | r\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n</table>\n" +
+			"<blockquote>\n  <p>q | r</p>\n</blockquote>\n",
+	}, {
+		"a | b\n--|--\n* i\n",
+		"<table>\n<thead>\n<tr>\n<th>a</th>\n<th>b</th>\n</tr>\n</thead>\n</table>\n" +
+			"<ul>\n<li>i</li>\n</ul>\n",
+	}}
+	detectors := append(mdblock.Detectors{Table{}}, mdblock.DefaultDetectors...)
+	for _, c := range cases {
+		checkHTML(test, c.input, c.expected, detectors, mdhtml.Renderer{})
+	}
+}
+
+func TestTableHTMLTopBlocks(test *testing.T) {
+	// Rows and cells are not emitted in mdblock.TopBlocks mode.
+	input := "a | b\n--|--\n1 | 2\n"
+	expected := "<table>\n</table>\n"
+	prep, _ := vfmd.QuickPrep(bytes.NewReader([]byte(input)))
+	detectors := append(mdblock.Detectors{Table{}}, mdblock.DefaultDetectors...)
+	tags, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.TopBlocks, detectors, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = mdhtml.Renderer{}.Render(buf, tags)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if buf.String() != expected {
+		test.Errorf("expected vs. got DIFF:\n%s", diff.Diff(expected, buf.String()))
+	}
+}
+
+func checkHTML(test *testing.T, input, expected string, detectors mdblock.Detectors, r mdhtml.Renderer) {
+	prep, _ := vfmd.QuickPrep(bytes.NewReader([]byte(input)))
+	tags, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, detectors, nil)
+	if err != nil {
+		test.Errorf("case %q parse error: %s", input, err)
+		return
+	}
+	buf := bytes.NewBuffer(nil)
+	err = r.Render(buf, tags)
+	if err != nil {
+		test.Errorf("case %q render error: %s", input, err)
+		return
+	}
+	if buf.String() != expected {
+		test.Errorf("case %q expected vs. got DIFF:\n%s",
+			input, diff.Diff(expected, buf.String()))
+	}
+}
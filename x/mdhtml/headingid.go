@@ -0,0 +1,124 @@
+package mdhtml
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+)
+
+// Slug converts header text into an identifier usable in URL fragments, the
+// way GitHub does: letters are lowercased, spaces changed to hyphens, and
+// punctuation other than hyphens and underscores removed. If nothing is left,
+// Slug returns "section".
+func Slug(text string) string {
+	slug := strings.Map(func(r rune) rune {
+		switch {
+		case unicode.IsLetter(r), unicode.IsDigit(r), r == '-', r == '_':
+			return unicode.ToLower(r)
+		case r == ' ':
+			return '-'
+		}
+		return -1
+	}, strings.TrimSpace(text))
+	if slug == "" {
+		return "section"
+	}
+	return slug
+}
+
+// HeadingIDs generates ids for headers, unique in a document, the same way
+// as a Renderer with AutoHeadingID does. The zero value is ready to use.
+type HeadingIDs struct {
+	used map[string]bool
+}
+
+// ID returns the id for the next header in the document, given its spans
+// terminated with md.End{}, and its plain text. If the spans end with a
+// "{#custom-id}" suffix, the custom id is returned (made unique like the
+// generated ones), and the suffix is not included in text.
+func (h *HeadingIDs) ID(spans []md.Tag) (id, text string) {
+	custom, trimmed, _ := customID(spans)
+	if trimmed != nil {
+		spans = trimmed
+	}
+	text = PlainText(spans)
+	return h.assign(custom, text), text
+}
+
+// assign returns the custom id if not empty, or the slug of text, with the
+// smallest suffix "-1", "-2", etc. which makes it unique. The id is then
+// marked as used. Custom ids are suffixed too, if already used by a previous
+// header.
+func (h *HeadingIDs) assign(custom, text string) string {
+	if h.used == nil {
+		h.used = map[string]bool{}
+	}
+	id := custom
+	if id == "" {
+		id = Slug(text)
+	}
+	unique := id
+	for i := 1; h.used[unique]; i++ {
+		unique = fmt.Sprintf("%s-%d", id, i)
+	}
+	h.used[unique] = true
+	return unique
+}
+
+var reCustomID = regexp.MustCompile(`[ \t\n]*\{#([^\s{}]+)\}[ \t\n]*$`)
+
+// customID looks for a "{#custom-id}" suffix at the end of the header spans,
+// which must be terminated with md.End{}. If found, it returns the id, a copy
+// of spans up to and including the md.End{} with the suffix removed, and the
+// tags following the md.End{}.
+func customID(spans []md.Tag) (id string, trimmed, rest []md.Tag) {
+	end, depth := 0, 0
+	for ; end < len(spans); end++ {
+		if (spans[end] == md.End{}) {
+			if depth == 0 {
+				break
+			}
+			depth--
+		} else if _, ok := spans[end].(md.Prose); !ok {
+			depth++
+		}
+	}
+	if end == 0 || end == len(spans) {
+		return "", nil, nil
+	}
+	prose, ok := spans[end-1].(md.Prose)
+	if !ok {
+		return "", nil, nil
+	}
+	text := []byte{}
+	for _, r := range prose {
+		text = append(text, r.Bytes...)
+	}
+	m := reCustomID.FindSubmatchIndex(text)
+	if m == nil {
+		return "", nil, nil
+	}
+	id = string(text[m[2]:m[3]])
+	// Cut the runs at the beginning of the suffix.
+	cut := m[0]
+	newProse := md.Prose{}
+	for _, r := range prose {
+		if cut <= 0 {
+			break
+		}
+		if len(r.Bytes) > cut {
+			r.Bytes = r.Bytes[:cut]
+		}
+		cut -= len(r.Bytes)
+		newProse = append(newProse, r)
+	}
+	trimmed = append(trimmed, spans[:end-1]...)
+	if len(bytes.TrimSpace(text[:m[0]])) > 0 || end == 1 {
+		trimmed = append(trimmed, newProse)
+	}
+	return id, append(trimmed, md.End{}), spans[end+1:]
+}
@@ -13,8 +13,50 @@ import (
 )
 
 func QuickRender(w io.Writer, blocks []md.Tag) error {
-	opt := Opt{
-		refs: htmlRefs(blocks),
+	return Renderer{}.Render(w, blocks)
+}
+
+// Renderer writes blocks as HTML. Its zero value renders XHTML-style output
+// with raw HTML escaped.
+type Renderer struct {
+	// HTML specifies how md.HTMLBlock and md.HTMLTag are rendered.
+	HTML HTMLPolicy
+	// Highlight, if not nil, is used for rendering contents of code blocks.
+	Highlight Highlighter
+	// HTML5 makes void elements rendered without the closing slash, e.g.
+	// <hr> instead of <hr />.
+	HTML5 bool
+	// Newlines specifies how line breaks inside paragraphs are rendered.
+	Newlines NewlinePolicy
+	// HeadingID, if not nil, is called for every header with its level and
+	// plain text contents, and returns the value of its id attribute, or ""
+	// for no id.
+	HeadingID func(level int, text string) string
+	// AutoHeadingID makes headers without an id from HeadingID get one
+	// generated with Slug from their text, and made unique in the document
+	// by appending "-1", "-2", etc. A header ending with "{#custom-id}" gets
+	// the specified id instead (suffixed the same way if already used), and
+	// the suffix is not rendered.
+	AutoHeadingID bool
+	// URLs specifies which URLs are allowed in links and images. If nil,
+	// the policy returned by DefaultURLPolicy is used.
+	URLs *URLPolicy
+	// Refs contains reference definitions used for resolving links and
+	// images, in addition to those found in the rendered document. The
+	// latter take precedence.
+	Refs []md.ReferenceResolutionBlock
+}
+
+// Render writes blocks to w as HTML.
+func (r Renderer) Render(w io.Writer, blocks []md.Tag) error {
+	opt := Opt{Renderer: r}
+	opt.refs = htmlRefs(blocks)
+	opt.ids = &HeadingIDs{}
+	for _, ref := range r.Refs {
+		id := strings.ToLower(ref.ReferenceID)
+		if _, found := opt.refs[id]; !found {
+			opt.refs[id] = htmlLinkInfo{URL: ref.URL, Title: ref.Title}
+		}
 	}
 	tags := blocks
 	for len(tags) > 0 {
@@ -45,19 +87,83 @@ func chkmoved(oldtags, newtags []md.Tag) error {
 	if &oldtags[0] != &newtags[0] {
 		return nil
 	}
-	return fmt.Errorf("vfmd: parsing failed to move over %T (%d tags remaining)",
+	return md.RenderErrorf(newtags, "parsing failed to move over %T (%d tags remaining)",
 		newtags[0], len(newtags))
 }
 
 type htmlLinkInfo struct {
 	URL, Title string
 }
+
+// HTMLPolicy specifies how raw HTML found in a document is rendered.
+type HTMLPolicy int
+
+const (
+	// HTMLEscape writes raw HTML to the output as escaped text.
+	HTMLEscape HTMLPolicy = iota
+	// HTMLPassThrough writes raw HTML to the output verbatim. It should only
+	// be used for trusted documents.
+	HTMLPassThrough
+	// HTMLDrop omits raw HTML from the output.
+	HTMLDrop
+)
+
+// Highlighter renders contents of a code block as an HTML fragment, which is
+// written verbatim inside <pre><code>. The language is empty for code blocks
+// without one (e.g. indented). If ok is false, the code is escaped as usual.
+type Highlighter func(language string, code []byte) (html []byte, ok bool)
+
+// NewlinePolicy specifies how line breaks inside paragraphs (other than
+// md.HardBreak) are rendered.
+type NewlinePolicy int
+
+const (
+	// NewlineKeep writes line breaks to the output verbatim.
+	NewlineKeep NewlinePolicy = iota
+	// NewlineSpace writes line breaks as spaces.
+	NewlineSpace
+	// NewlineBreak writes line breaks as <br /> elements, like GitHub does
+	// in comments.
+	NewlineBreak
+)
+
+// Opt is passed to Blocker and Spaner implementations. It contains the options
+// of the Renderer, and the rendering state.
 type Opt struct {
+	Renderer
+
 	refs                            map[string]htmlLinkInfo
+	ids                             *HeadingIDs
 	topPackedForP, bottomPackedForP bool
 	itemEndForP                     int
 }
 
+// nested returns options for a new level of nested blocks, with per-level
+// state reset.
+func (opt Opt) nested() Opt {
+	return Opt{
+		Renderer: opt.Renderer,
+		refs:     opt.refs,
+		ids:      opt.ids,
+	}
+}
+
+func (opt Opt) checkURL(url string) (string, bool) {
+	if opt.URLs == nil {
+		return defaultURLPolicy.Check(url)
+	}
+	return opt.URLs.Check(url)
+}
+
+// VoidEnd returns the string closing a void element, like <hr />, according
+// to opt.HTML5.
+func (opt Opt) VoidEnd() string {
+	if opt.HTML5 {
+		return ">"
+	}
+	return " />"
+}
+
 func (opt Opt) fillRef(refID string, ref *htmlLinkInfo) bool {
 	newref, found := opt.refs[strings.ToLower(refID)]
 	if !found {
@@ -125,6 +231,60 @@ func (c *Context) items(tags []md.Tag, parentRegion md.Raw, opt Opt) {
 		c.Err = chkmoved(tags, c.Tags)
 	}
 }
+
+// CodeBlock writes a <pre><code> element with the specified code, using
+// opt.Highlight if available.
+func (c *Context) CodeBlock(language string, code md.Prose, opt Opt) {
+	if language != "" {
+		c.Printf(`<pre><code class="language-%s">`, html.EscapeString(language))
+	} else {
+		c.Printf("<pre><code>")
+	}
+	if opt.Highlight != nil {
+		buf := []byte{}
+		for _, r := range code {
+			buf = append(buf, r.Bytes...)
+		}
+		if frag, ok := opt.Highlight(language, buf); ok {
+			c.write(frag)
+			c.Printf("</code></pre>\n")
+			return
+		}
+	}
+	for _, r := range code {
+		c.Printf("%s", html.EscapeString(string(r.Bytes)))
+	}
+	c.Printf("</code></pre>\n")
+}
+func (c *Context) header(level int, opt Opt) {
+	spans, rest, custom := c.Tags[1:], []md.Tag(nil), ""
+	if opt.AutoHeadingID {
+		if id, trimmed, after := customID(spans); trimmed != nil {
+			spans, rest, custom = trimmed, after, id
+		}
+	}
+	id := ""
+	if opt.HeadingID != nil {
+		id = opt.HeadingID(level, PlainText(spans))
+	}
+	if id == "" && opt.AutoHeadingID {
+		if opt.ids == nil {
+			opt.ids = &HeadingIDs{}
+		}
+		id = opt.ids.assign(custom, PlainText(spans))
+	}
+	if id != "" {
+		c.Printf(`<h%d id="%s">`, level, html.EscapeString(id))
+	} else {
+		c.Printf("<h%d>", level)
+	}
+	c.Spans(spans, opt)
+	if rest != nil && c.Err == nil {
+		// The spans were a trimmed copy.
+		c.Tags = rest
+	}
+	c.Printf("</h%d>\n", level)
+}
 func (c *Context) write(buf []byte) {
 	if c.Err != nil {
 		return
@@ -136,14 +296,10 @@ func htmlBlock(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 	c := Context{W: w, Tags: tags}
 	switch t := tags[0].(type) {
 	case md.AtxHeaderBlock:
-		c.Printf("<h%d>", t.Level)
-		c.Spans(tags[1:], opt)
-		c.Printf("</h%d>\n", t.Level)
+		c.header(t.Level, opt)
 		return c.Tags, c.Err
 	case md.SetextHeaderBlock:
-		c.Printf("<h%d>", t.Level)
-		c.Spans(tags[1:], opt)
-		c.Printf("</h%d>\n", t.Level)
+		c.header(t.Level, opt)
 		return c.Tags, c.Err
 	case md.NullBlock:
 		// TODO(akavel): don't print the empty line?
@@ -151,7 +307,7 @@ func htmlBlock(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 		return c.Tags[2:], c.Err
 	case md.QuoteBlock:
 		c.Printf("<blockquote>\n  ")
-		c.Blocks(tags[1:], Opt{refs: opt.refs})
+		c.Blocks(tags[1:], opt.nested())
 		c.Printf("</blockquote>\n")
 		return c.Tags, c.Err
 	case md.ParagraphBlock:
@@ -167,14 +323,10 @@ func htmlBlock(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 		}
 		return c.Tags, c.Err
 	case md.CodeBlock:
-		c.Printf("<pre><code>")
-		for _, r := range t.Prose {
-			c.Printf("%s", html.EscapeString(string(r.Bytes)))
-		}
-		c.Printf("</code></pre>\n")
+		c.CodeBlock("", t.Prose, opt)
 		return c.Tags[2:], c.Err
 	case md.HorizontalRuleBlock:
-		c.Printf("<hr />\n")
+		c.Printf("<hr%s\n", opt.VoidEnd())
 		return c.Tags[2:], c.Err
 	case md.OrderedListBlock:
 		var i int
@@ -194,11 +346,25 @@ func htmlBlock(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 		return c.Tags, c.Err
 	case md.ReferenceResolutionBlock:
 		return c.Tags[2:], nil
+	case md.HTMLBlock:
+		switch opt.HTML {
+		case HTMLPassThrough:
+			for _, r := range t.Raw {
+				c.write(r.Bytes)
+			}
+		case HTMLEscape:
+			buf := []byte{}
+			for _, r := range t.Raw {
+				buf = append(buf, r.Bytes...)
+			}
+			c.Printf("<p>%s</p>\n", html.EscapeString(
+				string(bytes.TrimRight(buf, mdutils.Whites))))
+		}
+		return c.Tags[2:], c.Err
 	default:
 		b, ok := t.(Blocker)
 		if !ok {
-			// TODO(akavel): return error's context (e.g. remaining tags?)
-			return tags, fmt.Errorf("vfmd: block type %T not supported yet", t)
+			return tags, md.RenderErrorf(tags, "block type %T not supported yet", t)
 		}
 		return b.HTMLBlock(c, opt)
 	}
@@ -208,30 +374,45 @@ func isBlank(line md.Run) bool {
 	return len(bytes.Trim(line.Bytes, " \t\n")) == 0
 }
 
+// isBlankAt reports whether region[i] is blank; lines out of range are
+// treated as blank.
+func isBlankAt(region md.Raw, i int) bool {
+	return i < 0 || i >= len(region) || isBlank(region[i])
+}
+
 func htmlItems(tags []md.Tag, w io.Writer, parentRegion md.Raw, opt Opt) ([]md.Tag, error) {
 	c := Context{W: w, Tags: tags}
 	for {
+		if len(c.Tags) == 0 {
+			return c.Tags, md.RenderErrorf(c.Tags, "missing md.End")
+		}
 		if (c.Tags[0] == md.End{}) {
 			return c.Tags[1:], nil
 		}
 
-		t := c.Tags[0].(md.ItemBlock)
-		opt := Opt{refs: opt.refs}
-		// top-packed?
+		t, ok := c.Tags[0].(md.ItemBlock)
+		if !ok {
+			return c.Tags, md.RenderErrorf(c.Tags, "expected md.ItemBlock, got %T", c.Tags[0])
+		}
 		n, m := len(t.Raw), len(parentRegion)
+		if n == 0 || m == 0 {
+			return c.Tags, md.RenderErrorf(c.Tags, "missing Raw lines of list item")
+		}
+		opt := opt.nested()
+		// top-packed?
 		ifirst, ilast := t.Raw[0].Line, t.Raw[n-1].Line
 		lfirst, llast := parentRegion[0].Line, parentRegion[m-1].Line
 		if n == m {
 			opt.topPackedForP = true
 		} else if ifirst == lfirst && !isBlank(t.Raw[n-1]) {
 			opt.topPackedForP = true
-		} else if ifirst > lfirst && !isBlank(parentRegion[ifirst-lfirst-1]) {
+		} else if ifirst > lfirst && !isBlankAt(parentRegion, ifirst-lfirst-1) {
 			opt.topPackedForP = true
 		}
 		// bottom-packed?
 		if n == m {
 			opt.bottomPackedForP = true
-		} else if ilast == llast && !isBlank(parentRegion[ifirst-lfirst-1]) {
+		} else if ilast == llast && !isBlankAt(parentRegion, ifirst-lfirst-1) {
 			opt.bottomPackedForP = true
 		} else if ilast < llast && !isBlank(t.Raw[n-1]) {
 			opt.bottomPackedForP = true
@@ -271,17 +452,60 @@ func htmlBlocks(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 }
 
 var (
-	tmplImage = template.Must(template.New("vfmd.<img>").Parse(
-		`<img src="{{.URL}}"` +
-			`{{if not (eq .alt "")}} alt="{{.alt}}"{{end}}` +
-			`{{if not (eq .Title "")}} title="{{.Title}}"{{end}}` +
-			` />`))
-	tmplLink = template.Must(template.New("vfmd.<a href>").Parse(
+	tmplImage      = imageTemplate(" />")
+	tmplImageHTML5 = imageTemplate(">")
+	tmplLink       = template.Must(template.New("vfmd.<a href>").Parse(
 		`<a href="{{.URL}}"` +
 			`{{if not (eq .Title "")}} title="{{.Title}}"{{end}}` +
 			`>`))
 )
 
+func imageTemplate(end string) *template.Template {
+	return template.Must(template.New("vfmd.<img>").Parse(
+		`<img src="{{.URL}}"` +
+			`{{if not (eq .alt "")}} alt="{{.alt}}"{{end}}` +
+			`{{if not (eq .Title "")}} title="{{.Title}}"{{end}}` +
+			end))
+}
+
+// PlainText returns text contents of span tags, up to the md.End closing
+// their parent.
+func PlainText(tags []md.Tag) string {
+	buf := []byte{}
+	depth := 0
+	for _, t := range tags {
+		switch t := t.(type) {
+		case md.End:
+			depth--
+			if depth < 0 {
+				return string(buf)
+			}
+			continue
+		case md.Prose:
+			for _, r := range t {
+				buf = append(buf, r.Bytes...)
+			}
+			continue
+		case md.Code:
+			buf = append(buf, t.Code...)
+		case md.Entity:
+			if t.Runes != nil {
+				buf = append(buf, string(t.Runes)...)
+			} else {
+				buf = append(buf, t.Text...)
+			}
+		case md.AutomaticLink:
+			buf = append(buf, t.Text...)
+		case md.Image:
+			buf = append(buf, t.AltText...)
+		case md.HardBreak:
+			buf = append(buf, ' ')
+		}
+		depth++
+	}
+	return string(buf)
+}
+
 func htmlSpans(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 	c := Context{W: w, Tags: tags}
 	for {
@@ -292,7 +516,16 @@ func htmlSpans(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 
 		case md.Prose:
 			for _, r := range t {
-				c.Printf("%s", html.EscapeString(string(r.Bytes)))
+				text := html.EscapeString(string(r.Bytes))
+				if strings.HasSuffix(text, "\n") {
+					switch opt.Newlines {
+					case NewlineSpace:
+						text = text[:len(text)-1] + " "
+					case NewlineBreak:
+						text = text[:len(text)-1] + "<br" + opt.VoidEnd() + "\n"
+					}
+				}
+				c.Printf("%s", text)
 			}
 			c.Tags = c.Tags[1:]
 		case md.Emphasis:
@@ -308,9 +541,30 @@ func htmlSpans(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 				3: "</em></strong>",
 			}[t.Level])
 		case md.AutomaticLink:
-			c.Printf(`<a href="%s">%s</a>`,
-				// FIXME(akavel): fully correct escaping
-				t.URL, html.EscapeString(t.Text))
+			if url, ok := opt.checkURL(t.URL); ok {
+				c.Printf(`<a href="%s">%s</a>`,
+					html.EscapeString(url), html.EscapeString(t.Text))
+			} else {
+				c.Printf("%s", html.EscapeString(t.Text))
+			}
+			c.Tags = c.Tags[2:]
+		case md.HTMLTag:
+			switch opt.HTML {
+			case HTMLPassThrough:
+				c.write(t.HTML)
+			case HTMLEscape:
+				c.Printf("%s", html.EscapeString(string(t.HTML)))
+			}
+			c.Tags = c.Tags[2:]
+		case md.HardBreak:
+			c.Printf("<br%s\n", opt.VoidEnd())
+			c.Tags = c.Tags[2:]
+		case md.Entity:
+			if t.Runes != nil {
+				c.write(t.Text)
+			} else {
+				c.Printf("%s", html.EscapeString(string(t.Text)))
+			}
 			c.Tags = c.Tags[2:]
 		case md.Code:
 			c.Printf(`<code>%s</code>`,
@@ -322,22 +576,29 @@ func htmlSpans(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 			if !found {
 				found = opt.fillRef(t.ReferenceID, &ref)
 			}
+			allowed := false
 			if found {
-				// FIXME(akavel): fully correct escaping
-				// FIXME(akavel): using URL below allows for "javascript:"; provide some way to protect against this (only whitelisted URL schemes?)
+				ref.URL, allowed = opt.checkURL(ref.URL)
+			}
+			switch {
+			case allowed:
+				// The template escapes the attributes; template.URL
+				// only disables filtering of the URL scheme, which is
+				// done by opt.checkURL.
 				if c.Err == nil {
 					c.Err = tmplLink.Execute(w, map[string]interface{}{
 						"Title": ref.Title,
 						"URL":   template.URL(ref.URL),
 					})
 				}
-			} else {
+			case !found:
 				c.Printf(`[`)
 			}
 			c.Spans(c.Tags[1:], opt)
-			if found {
+			switch {
+			case allowed:
 				c.Printf(`</a>`)
-			} else {
+			case !found:
 				rawEnd := mdutils.DeEscapeProse(md.Prose(t.RawEnd))
 				for _, r := range rawEnd {
 					c.write(r.Bytes)
@@ -350,17 +611,27 @@ func htmlSpans(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 				found = opt.fillRef(t.ReferenceID, &ref)
 			}
 			alt := string(t.AltText)
+			allowed := false
 			if found {
-				// FIXME(akavel): fully correct escaping
-				// FIXME(akavel): using URL below allows for "javascript:"; provide some way to protect against this (only whitelisted URL schemes?)
+				ref.URL, allowed = opt.checkURL(ref.URL)
+			}
+			switch {
+			case allowed:
+				// Escaped by the template, like in md.Link.
+				tmpl := tmplImage
+				if opt.HTML5 {
+					tmpl = tmplImageHTML5
+				}
 				if c.Err == nil {
-					c.Err = tmplImage.Execute(w, map[string]interface{}{
+					c.Err = tmpl.Execute(w, map[string]interface{}{
 						"Title": ref.Title,
 						"alt":   alt,
 						"URL":   template.URL(ref.URL),
 					})
 				}
-			} else {
+			case found:
+				c.Printf("%s", html.EscapeString(alt))
+			default:
 				c.Printf(`![%s`, alt)
 				rawEnd := mdutils.DeEscapeProse(md.Prose(t.RawEnd))
 				for _, r := range rawEnd {
@@ -372,8 +643,7 @@ func htmlSpans(tags []md.Tag, w io.Writer, opt Opt) ([]md.Tag, error) {
 		default:
 			s, ok := t.(Spaner)
 			if !ok {
-				// TODO(akavel): return error's context (e.g. remaining tags?)
-				return c.Tags, fmt.Errorf("vfmd: span type %T not supported, missing HTMLSpan method", t)
+				return c.Tags, md.RenderErrorf(c.Tags, "span type %T not supported, missing HTMLSpan method", t)
 			}
 			c.Tags, c.Err = s.HTMLSpan(c, opt)
 		}
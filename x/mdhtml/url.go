@@ -0,0 +1,76 @@
+package mdhtml
+
+import "strings"
+
+// URLPolicy specifies which URLs may be rendered in links, images and
+// automatic links. Elements with rejected URLs are rendered without them:
+// links and automatic links as their text, images as their alternate text.
+type URLPolicy struct {
+	// Schemes lists allowed URL schemes, in lower case and without the
+	// colon, e.g. "https". If nil, any scheme is allowed.
+	Schemes []string
+	// DenyRelative rejects URLs without a scheme.
+	DenyRelative bool
+	// Filter, if not nil, is called for URLs allowed by the fields above,
+	// and returns the URL to be rendered, or ok=false to reject it.
+	Filter func(url string) (newURL string, ok bool)
+}
+
+// DefaultURLPolicy returns a new copy of the policy used by a Renderer with
+// nil URLs. It allows relative URLs, and absolute ones with a few common,
+// harmless schemes.
+func DefaultURLPolicy() *URLPolicy {
+	return &URLPolicy{
+		Schemes: []string{"http", "https", "ftp", "mailto"},
+	}
+}
+
+var defaultURLPolicy = DefaultURLPolicy()
+
+// Check returns the URL which should be rendered in place of url, or ok=false
+// if it is rejected.
+func (p *URLPolicy) Check(url string) (newURL string, ok bool) {
+	scheme, relative := urlScheme(url)
+	switch {
+	case relative && p.DenyRelative:
+		return "", false
+	case !relative && p.Schemes != nil && !contains(p.Schemes, scheme):
+		return "", false
+	case p.Filter != nil:
+		return p.Filter(url)
+	}
+	return url, true
+}
+
+// urlScheme extracts the lowercased scheme of url. Like browsers do, it
+// ignores leading control characters and spaces, and tabs and newlines inside
+// the scheme.
+func urlScheme(url string) (scheme string, relative bool) {
+	url = strings.TrimLeft(url, "\x00\x01\x02\x03\x04\x05\x06\x07\x08\x09\x0a\x0b\x0c\x0d\x0e\x0f"+
+		"\x10\x11\x12\x13\x14\x15\x16\x17\x18\x19\x1a\x1b\x1c\x1d\x1e\x1f\x20")
+	buf := []byte{}
+	for i := 0; i < len(url); i++ {
+		c := url[i]
+		switch {
+		case c == ':':
+			return strings.ToLower(string(buf)), len(buf) == 0
+		case c == '\t' || c == '\n' || c == '\r':
+			continue
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z':
+		case len(buf) > 0 && ('0' <= c && c <= '9' || c == '+' || c == '-' || c == '.'):
+		default:
+			return "", true
+		}
+		buf = append(buf, c)
+	}
+	return "", true
+}
+
+func contains(list []string, s string) bool {
+	for _, x := range list {
+		if x == s {
+			return true
+		}
+	}
+	return false
+}
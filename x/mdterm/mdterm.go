@@ -0,0 +1,483 @@
+// Package mdterm renders md.Tag streams for reading in a terminal, styled
+// with ANSI escape sequences: emphasis is shown in bold or italics, links are
+// underlined and colored, with their URLs shown, code blocks are drawn in
+// boxes, and text is wrapped to the terminal width. Styling can be disabled,
+// e.g. for terminals not supporting it.
+package mdterm
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdtree"
+	"gopkg.in/akavel/vfmd.v1/mdutils"
+)
+
+// SGR (Select Graphic Rendition) parameters used for styling elements.
+const (
+	styleBold     = "1"
+	styleDim      = "2"
+	styleItalic   = "3"
+	styleBoldItal = "1;3"
+	styleLink     = "4;34"
+	styleCode     = "36"
+	styleHeader1  = "1;4;35"
+	styleHeader2  = "1;35"
+	styleHeaderN  = "1"
+
+	reset = "\x1b[0m"
+)
+
+var reEscape = regexp.MustCompile("\x1b\\[[0-9;]*m")
+
+func QuickRender(w io.Writer, blocks []md.Tag) error {
+	return Renderer{Width: 80}.Render(w, blocks)
+}
+
+// Renderer writes blocks as text styled with ANSI escape sequences.
+type Renderer struct {
+	// Width is the width of the terminal. Paragraphs and headers are
+	// wrapped to fit in it. If zero, they are not wrapped.
+	Width int
+	// NoColor disables all escape sequences in the output.
+	NoColor bool
+	// Refs contains reference definitions used for resolving links, in
+	// addition to those found in the rendered document. The latter take
+	// precedence.
+	Refs []md.ReferenceResolutionBlock
+}
+
+// Render writes blocks to w.
+func (r Renderer) Render(w io.Writer, blocks []md.Tag) error {
+	c := Context{Tags: blocks, Width: r.Width, NoColor: r.NoColor, refs: map[string]string{}}
+	addRef := func(ref md.ReferenceResolutionBlock) {
+		id := strings.ToLower(ref.ReferenceID)
+		if _, found := c.refs[id]; !found {
+			c.refs[id] = ref.URL
+		}
+	}
+	for _, t := range blocks {
+		if ref, ok := t.(md.ReferenceResolutionBlock); ok {
+			addRef(ref)
+		}
+	}
+	for _, ref := range r.Refs {
+		addRef(ref)
+	}
+	lines := c.blocks(func() bool { return len(c.Tags) == 0 })
+	if c.Err != nil {
+		return c.Err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+// Spaner is implemented by span tags which are not part of package md, to
+// render themselves for a terminal.
+type Spaner interface {
+	TermSpan(*Context) string
+}
+
+// Blocker is implemented by block tags which are not part of package md, to
+// render themselves as lines for a terminal, not wider than Context.Width if
+// possible.
+type Blocker interface {
+	TermBlock(*Context) []string
+}
+
+// Context holds the rendering state. Blocker and Spaner implementations must
+// move Tags past the rendered tag and its md.End{}.
+type Context struct {
+	Tags []md.Tag
+	Err  error
+	// Width is the maximum width of lines in the current block, or 0 if
+	// lines should not be wrapped.
+	Width   int
+	NoColor bool
+
+	refs map[string]string
+	// styles of the spans being rendered, outermost first
+	styles []string
+	// tight is true in list items without blank lines, where blocks are not
+	// separated.
+	tight bool
+}
+
+// Styled returns the text rendered by render, enclosed in escape sequences
+// setting the specified style, then restoring styles of the enclosing spans.
+func (c *Context) Styled(sgr string, render func() string) string {
+	if c.NoColor {
+		return render()
+	}
+	c.styles = append(c.styles, sgr)
+	text := render()
+	c.styles = c.styles[:len(c.styles)-1]
+	restore := ""
+	for _, s := range c.styles {
+		restore += csi(s)
+	}
+	return csi(sgr) + text + reset + restore
+}
+
+// Style returns text enclosed in escape sequences setting the specified
+// style.
+func (c *Context) Style(sgr, text string) string {
+	return c.Styled(sgr, func() string { return text })
+}
+
+func csi(sgr string) string { return "\x1b[" + sgr + "m" }
+
+// Width returns the number of columns taken by s in a terminal, not counting
+// escape sequences.
+func Width(s string) int {
+	return utf8.RuneCountInString(reEscape.ReplaceAllString(s, ""))
+}
+
+// Sanitize replaces control characters in text, so that escape sequences
+// from the document can't affect the terminal.
+func Sanitize(text []byte) string {
+	return strings.Map(func(r rune) rune {
+		if r != '\n' && unicode.IsControl(r) {
+			return utf8.RuneError
+		}
+		return r
+	}, string(text))
+}
+
+// Blocks renders blocks from c.Tags up to and including the closing
+// md.End{}, separating them with blank lines.
+func (c *Context) Blocks() []string {
+	return c.blocks(func() bool {
+		if len(c.Tags) == 0 {
+			c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+			return true
+		}
+		if (c.Tags[0] == md.End{}) {
+			c.Tags = c.Tags[1:]
+			return true
+		}
+		return false
+	})
+}
+
+func (c *Context) blocks(end func() bool) []string {
+	var lines []string
+	for c.Err == nil && !end() {
+		block := c.block()
+		if len(block) == 0 {
+			continue
+		}
+		if len(lines) > 0 && !c.tight {
+			lines = append(lines, "")
+		}
+		lines = append(lines, block...)
+	}
+	return lines
+}
+
+// Spans renders spans from c.Tags up to and including the closing md.End{}.
+// Line breaks in the source are replaced with spaces, and hard breaks are
+// rendered as "\n".
+func (c *Context) Spans() string {
+	buf := bytes.NewBuffer(nil)
+	for c.Err == nil {
+		if len(c.Tags) == 0 {
+			c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+			break
+		}
+		if (c.Tags[0] == md.End{}) {
+			c.Tags = c.Tags[1:]
+			break
+		}
+		buf.WriteString(c.span())
+	}
+	return buf.String()
+}
+
+// Skip moves c.Tags past the closing md.End{} of the current level, without
+// rendering anything.
+func (c *Context) Skip() {
+	end := mdtree.EndOf(c.Tags)
+	if end < 0 {
+		c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+		return
+	}
+	c.Tags = c.Tags[end+1:]
+}
+
+// Nest renders blocks from c.Tags up to and including the closing md.End{},
+// reducing the width by the width of the prefix, and prefixing the lines with
+// it. The first line is prefixed with first instead, which should be of the
+// same width.
+func (c *Context) Nest(first, rest string) []string {
+	lines := c.indented(Width(rest), false)
+	for i := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		if lines[i] == "" {
+			lines[i] = strings.TrimRight(prefix, " ")
+		} else {
+			lines[i] = prefix + lines[i]
+		}
+	}
+	return lines
+}
+
+// indented renders blocks like Blocks, with the width reduced by n.
+func (c *Context) indented(n int, tight bool) []string {
+	width, wasTight := c.Width, c.tight
+	c.tight = tight
+	if width > 0 {
+		c.Width -= n
+		if c.Width < 1 {
+			c.Width = 1
+		}
+	}
+	lines := c.Blocks()
+	c.Width, c.tight = width, wasTight
+	return lines
+}
+
+// Wrap splits text into lines not wider than c.Width, breaking them at
+// whitespace, and at every "\n". Any whitespace between words is replaced
+// with a single space. Styles active at the end of a line are reset, and set
+// again at the beginning of the next one.
+func (c *Context) Wrap(text string) []string {
+	var lines []string
+	active := ""
+	for _, segment := range strings.Split(text, "\n") {
+		line, n := active, 0
+		for _, word := range strings.Fields(segment) {
+			k := Width(word)
+			switch {
+			case n == 0:
+				line, n = line+word, k
+			case c.Width > 0 && n+1+k > c.Width:
+				lines = append(lines, endLine(line, active))
+				line, n = active+word, k
+			default:
+				line, n = line+" "+word, n+1+k
+			}
+			for _, esc := range reEscape.FindAllString(word, -1) {
+				if esc == reset {
+					active = ""
+				} else {
+					active += esc
+				}
+			}
+		}
+		lines = append(lines, endLine(line, active))
+	}
+	return lines
+}
+
+func endLine(line, active string) string {
+	if active == "" {
+		return line
+	}
+	return line + reset
+}
+
+// Box returns lines drawn in a box.
+func Box(lines []string) []string {
+	width := 0
+	for _, l := range lines {
+		if n := Width(l); n > width {
+			width = n
+		}
+	}
+	box := []string{"┌" + strings.Repeat("─", width+2) + "┐"}
+	for _, l := range lines {
+		box = append(box, "│ "+l+strings.Repeat(" ", width-Width(l))+" │")
+	}
+	return append(box, "└"+strings.Repeat("─", width+2)+"┘")
+}
+
+// Code returns the lines of code, with control characters replaced.
+func Code(code md.Prose) []string {
+	buf := []byte{}
+	for _, r := range code {
+		buf = append(buf, r.Bytes...)
+	}
+	return strings.Split(strings.TrimRight(Sanitize(buf), "\n"), "\n")
+}
+
+func (c *Context) block() []string {
+	tags := c.Tags
+	t := c.Tags[0]
+	c.Tags = c.Tags[1:]
+	switch t := t.(type) {
+	case md.NullBlock, md.HTMLBlock, md.ReferenceResolutionBlock:
+		c.Skip()
+		return nil
+	case md.ParagraphBlock:
+		return c.Wrap(c.Spans())
+	case md.AtxHeaderBlock:
+		return c.header(t.Level)
+	case md.SetextHeaderBlock:
+		return c.header(t.Level)
+	case md.CodeBlock:
+		c.Tags = c.Tags[1:]
+		return Box(Code(t.Prose))
+	case md.QuoteBlock:
+		mark := c.Style(styleDim, "│") + " "
+		return c.Nest(mark, mark)
+	case md.HorizontalRuleBlock:
+		c.Tags = c.Tags[1:]
+		width := c.Width
+		if width == 0 {
+			width = 40
+		}
+		return []string{c.Style(styleDim, strings.Repeat("─", width))}
+	case md.UnorderedListBlock:
+		return c.items(func(int) string { return "•" })
+	case md.OrderedListBlock:
+		start := 1
+		fmt.Sscanf(string(bytes.TrimSpace(t.Starter.Bytes)), "%d", &start)
+		return c.items(func(i int) string { return fmt.Sprintf("%d.", start+i) })
+	default:
+		b, ok := t.(Blocker)
+		if !ok {
+			c.Err = md.RenderErrorf(tags, "block type %T not supported, missing TermBlock method", t)
+			return nil
+		}
+		c.Tags = tags
+		return b.TermBlock(c)
+	}
+}
+
+func (c *Context) header(level int) []string {
+	style := styleHeaderN
+	switch level {
+	case 1:
+		style = styleHeader1
+	case 2:
+		style = styleHeader2
+	}
+	lines := c.Wrap(c.Styled(style, c.Spans))
+	if !c.NoColor || level > 2 {
+		return lines
+	}
+	underline := "="
+	if level == 2 {
+		underline = "-"
+	}
+	n := 0
+	for _, l := range lines {
+		if k := Width(l); k > n {
+			n = k
+		}
+	}
+	return append(lines, strings.Repeat(underline, n))
+}
+
+// items renders list items, prefixing them with markers aligned to the right.
+func (c *Context) items(marker func(i int) string) []string {
+	// The last marker is the longest one.
+	width := Width(marker(mdtree.CountItems(c.Tags) - 1))
+	indent := strings.Repeat(" ", width+1)
+	var lines []string
+	blankAfter := false
+	c.Err = mdtree.Items(&c.Tags, func(i int, item md.ItemBlock) error {
+		if i > 0 && blankAfter {
+			lines = append(lines, "")
+		}
+		n := len(item.Raw)
+		blankAfter = n > 0 && mdutils.IsBlank(item.Raw[n-1].Bytes)
+		m := marker(i)
+		first := strings.Repeat(" ", width-Width(m)) + c.Style(styleBold, m) + " "
+		for j, l := range c.indented(width+1, !mdutils.HasBlankLine(item.Raw)) {
+			switch {
+			case j == 0:
+				l = first + l
+			case l != "":
+				l = indent + l
+			}
+			lines = append(lines, l)
+		}
+		return c.Err
+	})
+	return lines
+}
+
+var emphasisStyles = []string{styleItalic, styleBold, styleBoldItal}
+
+func (c *Context) span() string {
+	tags := c.Tags
+	t := c.Tags[0]
+	c.Tags = c.Tags[1:]
+	switch t := t.(type) {
+	case md.Prose:
+		buf := []byte{}
+		for _, r := range t {
+			buf = append(buf, r.Bytes...)
+		}
+		return strings.Replace(Sanitize(buf), "\n", " ", -1)
+	case md.Emphasis:
+		level := t.Level
+		if level > len(emphasisStyles) {
+			level = len(emphasisStyles)
+		}
+		return c.Styled(emphasisStyles[level-1], c.Spans)
+	case md.Code:
+		c.Tags = c.Tags[1:]
+		return c.Style(styleCode, Sanitize(t.Code))
+	case md.Link:
+		url, found := t.URL, t.URL != ""
+		if !found {
+			url, found = c.refs[strings.ToLower(t.ReferenceID)]
+		}
+		if !found {
+			text := c.Spans()
+			buf := []byte{}
+			for _, r := range mdutils.DeEscapeProse(md.Prose(t.RawEnd)) {
+				buf = append(buf, r.Bytes...)
+			}
+			return "[" + text + Sanitize(buf)
+		}
+		text := c.Styled(styleLink, c.Spans)
+		url = Sanitize([]byte(url))
+		plain := reEscape.ReplaceAllString(text, "")
+		if url == plain || url == "mailto:"+plain {
+			return text
+		}
+		return text + " " + c.Style(styleDim, "("+url+")")
+	case md.Image:
+		c.Tags = c.Tags[1:]
+		return c.Style(styleDim, "["+Sanitize([]byte(t.AltText))+"]")
+	case md.AutomaticLink:
+		c.Tags = c.Tags[1:]
+		return c.Style(styleLink, Sanitize([]byte(t.Text)))
+	case md.HTMLTag:
+		c.Tags = c.Tags[1:]
+		return ""
+	case md.Entity:
+		c.Tags = c.Tags[1:]
+		if t.Runes != nil {
+			return Sanitize([]byte(string(t.Runes)))
+		}
+		return string(t.Text)
+	case md.HardBreak:
+		c.Tags = c.Tags[1:]
+		return "\n"
+	default:
+		s, ok := t.(Spaner)
+		if !ok {
+			c.Err = md.RenderErrorf(tags, "span type %T not supported, missing TermSpan method", t)
+			return ""
+		}
+		c.Tags = tags
+		return s.TermSpan(c)
+	}
+}
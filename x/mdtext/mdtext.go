@@ -0,0 +1,408 @@
+// Package mdtext renders md.Tag streams as readable plain text, e.g. for the
+// text/plain part of an e-mail. Markup is stripped, paragraphs can be wrapped
+// to a chosen width, lists are indented and numbered, and links are followed
+// by their URLs in parentheses.
+package mdtext
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdtree"
+	"gopkg.in/akavel/vfmd.v1/mdutils"
+)
+
+func QuickRender(w io.Writer, blocks []md.Tag) error {
+	return Renderer{}.Render(w, blocks)
+}
+
+// Renderer writes blocks as plain text. Its zero value doesn't wrap
+// paragraphs.
+type Renderer struct {
+	// Width is the maximum length of lines of paragraphs and headers, in
+	// characters. Words longer than that are put on lines of their own. If
+	// zero, every paragraph is written in a single line (or more, if it
+	// contains hard line breaks).
+	Width int
+	// Refs contains reference definitions used for resolving links, in
+	// addition to those found in the rendered document. The latter take
+	// precedence.
+	Refs []md.ReferenceResolutionBlock
+}
+
+// Render writes blocks to w as plain text.
+func (r Renderer) Render(w io.Writer, blocks []md.Tag) error {
+	c := Context{Tags: blocks, Width: r.Width, refs: map[string]string{}}
+	for _, refs := range [][]md.Tag{blocks, refTags(r.Refs)} {
+		for _, t := range refs {
+			ref, ok := t.(md.ReferenceResolutionBlock)
+			if !ok {
+				continue
+			}
+			id := strings.ToLower(ref.ReferenceID)
+			if _, found := c.refs[id]; !found {
+				c.refs[id] = ref.URL
+			}
+		}
+	}
+	lines := c.blocks(func() bool { return len(c.Tags) == 0 })
+	if c.Err != nil {
+		return c.Err
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	_, err := io.WriteString(w, strings.Join(lines, "\n")+"\n")
+	return err
+}
+
+func refTags(refs []md.ReferenceResolutionBlock) []md.Tag {
+	tags := make([]md.Tag, len(refs))
+	for i := range refs {
+		tags[i] = refs[i]
+	}
+	return tags
+}
+
+// Spaner is implemented by span tags which are not part of package md, to
+// render themselves as plain text.
+type Spaner interface {
+	TextSpan(*Context) string
+}
+
+// Blocker is implemented by block tags which are not part of package md, to
+// render themselves as lines of plain text, not longer than Context.Width if
+// possible.
+type Blocker interface {
+	TextBlock(*Context) []string
+}
+
+// Context holds the rendering state. Blocker and Spaner implementations must
+// move Tags past the rendered tag and its md.End{}.
+type Context struct {
+	Tags []md.Tag
+	Err  error
+	// Width is the maximum length of lines in the current block, or 0 if
+	// lines should not be wrapped.
+	Width int
+
+	refs map[string]string
+	// tight is true in list items without blank lines, where blocks are not
+	// separated.
+	tight bool
+}
+
+// Blocks renders blocks from c.Tags up to and including the closing
+// md.End{}, separating them with blank lines.
+func (c *Context) Blocks() []string {
+	lines := c.blocks(func() bool {
+		if len(c.Tags) == 0 {
+			c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+			return true
+		}
+		if (c.Tags[0] == md.End{}) {
+			c.Tags = c.Tags[1:]
+			return true
+		}
+		return false
+	})
+	return lines
+}
+
+func (c *Context) blocks(end func() bool) []string {
+	var lines []string
+	for c.Err == nil && !end() {
+		block := c.block()
+		if len(block) == 0 {
+			continue
+		}
+		if len(lines) > 0 && !c.tight {
+			lines = append(lines, "")
+		}
+		lines = append(lines, block...)
+	}
+	return lines
+}
+
+// Spans renders spans from c.Tags up to and including the closing md.End{}.
+// Line breaks in the source are replaced with spaces, and hard breaks are
+// rendered as "\n".
+func (c *Context) Spans() string {
+	buf := bytes.NewBuffer(nil)
+	for c.Err == nil {
+		if len(c.Tags) == 0 {
+			c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+			break
+		}
+		if (c.Tags[0] == md.End{}) {
+			c.Tags = c.Tags[1:]
+			break
+		}
+		buf.WriteString(c.span())
+	}
+	return buf.String()
+}
+
+// Skip moves c.Tags past the closing md.End{} of the current level, without
+// rendering anything.
+func (c *Context) Skip() {
+	end := mdtree.EndOf(c.Tags)
+	if end < 0 {
+		c.Err = md.RenderErrorf(c.Tags, "missing md.End")
+		return
+	}
+	c.Tags = c.Tags[end+1:]
+}
+
+// Nest renders blocks from c.Tags up to and including the closing md.End{},
+// reducing the width by the length of the prefix, and prefixing the lines with
+// it. The first line is prefixed with first instead, which should be of the
+// same length.
+func (c *Context) Nest(first, rest string) []string {
+	lines := c.indented(utf8.RuneCountInString(rest), false)
+	for i := range lines {
+		prefix := rest
+		if i == 0 {
+			prefix = first
+		}
+		lines[i] = strings.TrimRight(prefix+lines[i], " ")
+	}
+	return lines
+}
+
+// indented renders blocks like Blocks, with the width reduced by n.
+func (c *Context) indented(n int, tight bool) []string {
+	width, wasTight := c.Width, c.tight
+	c.tight = tight
+	if width > 0 {
+		c.Width -= n
+		if c.Width < 1 {
+			c.Width = 1
+		}
+	}
+	lines := c.Blocks()
+	c.Width, c.tight = width, wasTight
+	return lines
+}
+
+// Wrap splits text into lines not longer than c.Width, breaking them at
+// whitespace, and at every "\n". Any whitespace between words is replaced
+// with a single space.
+func (c *Context) Wrap(text string) []string {
+	var lines []string
+	for _, segment := range strings.Split(text, "\n") {
+		line, n := "", 0
+		for _, word := range strings.Fields(segment) {
+			k := utf8.RuneCountInString(word)
+			switch {
+			case n == 0:
+				line, n = word, k
+			case c.Width > 0 && n+1+k > c.Width:
+				lines = append(lines, line)
+				line, n = word, k
+			default:
+				line, n = line+" "+word, n+1+k
+			}
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func (c *Context) block() []string {
+	tags := c.Tags
+	t := c.Tags[0]
+	c.Tags = c.Tags[1:]
+	switch t := t.(type) {
+	case md.NullBlock, md.ReferenceResolutionBlock:
+		c.Skip()
+		return nil
+	case md.HTMLBlock:
+		c.Tags = c.Tags[1:]
+		return c.htmlBlock(t.Raw)
+	case md.ParagraphBlock:
+		return c.Wrap(c.Spans())
+	case md.AtxHeaderBlock:
+		return c.header(t.Level)
+	case md.SetextHeaderBlock:
+		return c.header(t.Level)
+	case md.CodeBlock:
+		c.Tags = c.Tags[1:]
+		return Verbatim(t.Prose)
+	case md.QuoteBlock:
+		return c.Nest("> ", "> ")
+	case md.HorizontalRuleBlock:
+		c.Tags = c.Tags[1:]
+		if c.Width > 0 {
+			return []string{strings.Repeat("-", c.Width)}
+		}
+		return []string{"* * *"}
+	case md.UnorderedListBlock:
+		return c.items(func(int) string { return "*" })
+	case md.OrderedListBlock:
+		start := 1
+		fmt.Sscanf(string(bytes.TrimSpace(t.Starter.Bytes)), "%d", &start)
+		return c.items(func(i int) string { return fmt.Sprintf("%d.", start+i) })
+	default:
+		b, ok := t.(Blocker)
+		if !ok {
+			c.Err = md.RenderErrorf(tags, "block type %T not supported, missing TextBlock method", t)
+			return nil
+		}
+		c.Tags = tags
+		return b.TextBlock(c)
+	}
+}
+
+// Verbatim returns the lines of code indented with four spaces.
+func Verbatim(code md.Prose) []string {
+	buf := []byte{}
+	for _, r := range code {
+		buf = append(buf, r.Bytes...)
+	}
+	lines := strings.Split(strings.TrimRight(string(buf), "\n"), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight("    "+lines[i], " ")
+	}
+	return lines
+}
+
+// reHTMLMarkup matches HTML tags and comments, including an unclosed comment
+// at the end of a block.
+var reHTMLMarkup = regexp.MustCompile(`(?s)<!--.*?(?:-->|$)|<[^>]*>`)
+
+// htmlBlock renders the text of an HTML block with the tags stripped and the
+// entities decoded, wrapping each of its non-blank lines separately.
+func (c *Context) htmlBlock(raw md.Raw) []string {
+	buf := []byte{}
+	for _, r := range raw {
+		buf = append(buf, r.Bytes...)
+	}
+	text := html.UnescapeString(reHTMLMarkup.ReplaceAllString(string(buf), ""))
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimSpace(l); l != "" {
+			lines = append(lines, l)
+		}
+	}
+	if len(lines) == 0 {
+		return nil
+	}
+	return c.Wrap(strings.Join(lines, "\n"))
+}
+
+func (c *Context) header(level int) []string {
+	lines := c.Wrap(c.Spans())
+	underline := ""
+	switch level {
+	case 1:
+		underline = "="
+	case 2:
+		underline = "-"
+	default:
+		return lines
+	}
+	n := 0
+	for _, l := range lines {
+		if k := utf8.RuneCountInString(l); k > n {
+			n = k
+		}
+	}
+	return append(lines, strings.Repeat(underline, n))
+}
+
+// items renders list items, prefixing them with markers aligned to the right.
+func (c *Context) items(marker func(i int) string) []string {
+	// The last marker is the longest one.
+	width := len(marker(mdtree.CountItems(c.Tags) - 1))
+	indent := strings.Repeat(" ", width+1)
+	var lines []string
+	blankAfter := false
+	c.Err = mdtree.Items(&c.Tags, func(i int, item md.ItemBlock) error {
+		if i > 0 && blankAfter {
+			lines = append(lines, "")
+		}
+		n := len(item.Raw)
+		blankAfter = n > 0 && mdutils.IsBlank(item.Raw[n-1].Bytes)
+		first := fmt.Sprintf("%*s ", width, marker(i))
+		for j, l := range c.indented(width+1, !mdutils.HasBlankLine(item.Raw)) {
+			switch {
+			case j == 0:
+				l = strings.TrimRight(first+l, " ")
+			case l != "":
+				l = indent + l
+			}
+			lines = append(lines, l)
+		}
+		return c.Err
+	})
+	return lines
+}
+
+func (c *Context) span() string {
+	tags := c.Tags
+	t := c.Tags[0]
+	c.Tags = c.Tags[1:]
+	switch t := t.(type) {
+	case md.Prose:
+		buf := []byte{}
+		for _, r := range t {
+			buf = append(buf, r.Bytes...)
+		}
+		return strings.Replace(string(buf), "\n", " ", -1)
+	case md.Emphasis:
+		return c.Spans()
+	case md.Code:
+		c.Tags = c.Tags[1:]
+		return string(t.Code)
+	case md.Link:
+		url, found := t.URL, t.URL != ""
+		if !found {
+			url, found = c.refs[strings.ToLower(t.ReferenceID)]
+		}
+		text := c.Spans()
+		switch {
+		case !found:
+			buf := []byte{}
+			for _, r := range mdutils.DeEscapeProse(md.Prose(t.RawEnd)) {
+				buf = append(buf, r.Bytes...)
+			}
+			return "[" + text + string(buf)
+		case url == text, url == "mailto:"+text:
+			return text
+		}
+		return text + " (" + url + ")"
+	case md.Image:
+		c.Tags = c.Tags[1:]
+		return t.AltText
+	case md.AutomaticLink:
+		c.Tags = c.Tags[1:]
+		return t.Text
+	case md.HTMLTag:
+		c.Tags = c.Tags[1:]
+		return ""
+	case md.Entity:
+		c.Tags = c.Tags[1:]
+		if t.Runes != nil {
+			return string(t.Runes)
+		}
+		return string(t.Text)
+	case md.HardBreak:
+		c.Tags = c.Tags[1:]
+		return "\n"
+	default:
+		s, ok := t.(Spaner)
+		if !ok {
+			c.Err = md.RenderErrorf(tags, "span type %T not supported, missing TextSpan method", t)
+			return ""
+		}
+		c.Tags = tags
+		return s.TextSpan(c)
+	}
+}
@@ -0,0 +1,31 @@
+//go:build go1.18
+// +build go1.18
+
+package mdtoc
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+// FuzzParse checks that no input makes Parse or the writers panic.
+func FuzzParse(f *testing.F) {
+	f.Add([]byte(sample), false)
+	f.Add([]byte(sample), true)
+	f.Add([]byte("# a {#x}\n# a\n\n> ## `b` [c](d) ![e](f)\n* ###### g #\n"), true)
+	f.Fuzz(func(t *testing.T, input []byte, nested bool) {
+		entries, err := Parse(bytes.NewReader(input), Options{Nested: nested})
+		if err != nil {
+			return
+		}
+		err = WriteHTML(ioutil.Discard, entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = WriteMarkdown(ioutil.Discard, entries)
+		if err != nil {
+			t.Fatal(err)
+		}
+	})
+}
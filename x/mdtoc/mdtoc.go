@@ -0,0 +1,174 @@
+// Package mdtoc extracts a table of contents from headers of a Markdown
+// document, and renders it as a HTML or Markdown list.
+//
+// Header ids are generated the same way as by mdhtml.Renderer with
+// AutoHeadingID enabled, so links in the table of contents lead to the headers
+// in the HTML rendered from the same document. (Parse without Options.Nested
+// doesn't see headers inside blockquotes and lists, so this doesn't hold if
+// they have the same text as ones at the top level.)
+package mdtoc // import "gopkg.in/akavel/vfmd.v1/x/mdtoc"
+
+import (
+	"bytes"
+	"fmt"
+	"html"
+	"io"
+	"strings"
+
+	"gopkg.in/akavel/vfmd.v1"
+	"gopkg.in/akavel/vfmd.v1/md"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/mdspan"
+	"gopkg.in/akavel/vfmd.v1/mdutils"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+)
+
+// Entry is a header in the table of contents.
+type Entry struct {
+	Level int
+	// Text is the plain text of the header, without any markup.
+	Text string
+	// ID is the header id, as generated by mdhtml.HeadingIDs.
+	ID string
+	// Line is the number of the first line of the header in the source,
+	// starting at 0.
+	Line int
+	// Children are the entries for headers following this one, up to the
+	// next header of the same or lower level.
+	Children []*Entry
+}
+
+// Options specify which headers are included in the table of contents.
+type Options struct {
+	// Nested includes headers found inside blockquotes and lists.
+	Nested bool
+	// Detectors and SpanDetectors are passed to mdblock.QuickParse by Parse,
+	// and SpanDetectors are used for parsing the header text. If nil,
+	// defaults are used.
+	Detectors     mdblock.Detectors
+	SpanDetectors []mdspan.Detector
+}
+
+// Parse reads a Markdown document from r, and returns its table of contents.
+// Only the blocks are parsed, in mdblock.TopBlocks mode unless opt.Nested is
+// set, and then just the header text is parsed for spans.
+func Parse(r io.Reader, opt Options) ([]*Entry, error) {
+	prep, err := vfmd.QuickPrep(r)
+	if err != nil {
+		return nil, err
+	}
+	mode := mdblock.TopBlocks
+	if opt.Nested {
+		mode = mdblock.BlocksOnly
+	}
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mode, opt.Detectors, opt.SpanDetectors)
+	if err != nil {
+		return nil, err
+	}
+	return Build(blocks, opt), nil
+}
+
+// Build returns the table of contents for blocks, parsed in any mode. If the
+// header tags are not followed by spans, the header text is parsed from
+// their Raw lines.
+func Build(blocks []md.Tag, opt Options) []*Entry {
+	root := &Entry{}
+	stack := []*Entry{root}
+	ids := mdhtml.HeadingIDs{}
+	depth := 0
+	for i, t := range blocks {
+		level := 0
+		var raw md.Raw
+		line := 0
+		switch t := t.(type) {
+		case md.End:
+			depth--
+			continue
+		case md.Prose:
+			continue
+		case md.AtxHeaderBlock:
+			level, raw, line = t.Level, md.Raw{atxText(t.Raw[0])}, t.Raw[0].Line
+		case md.SetextHeaderBlock:
+			level, raw, line = t.Level, md.Raw{trimRun(t.Raw[0])}, t.Raw[0].Line
+		}
+		depth++
+		if level == 0 {
+			continue
+		}
+		spans := blocks[i+1:]
+		if len(spans) == 0 || (spans[0] == md.End{}) {
+			spans = append(mdspan.ParseRegion(md.Region(raw), opt.SpanDetectors), md.End{})
+		}
+		e := &Entry{Level: level, Line: line}
+		// Ids are assigned to all headers, as done by mdhtml.
+		e.ID, e.Text = ids.ID(spans)
+		if depth > 1 && !opt.Nested {
+			continue
+		}
+		for len(stack) > 1 && stack[len(stack)-1].Level >= level {
+			stack = stack[:len(stack)-1]
+		}
+		parent := stack[len(stack)-1]
+		parent.Children = append(parent.Children, e)
+		stack = append(stack, e)
+	}
+	return root.Children
+}
+
+// atxText returns the header text from the line of an ATX header.
+func atxText(line md.Run) md.Run {
+	line.Bytes = bytes.Trim(bytes.TrimRight(line.Bytes, "\n"), "#")
+	return trimRun(line)
+}
+
+func trimRun(run md.Run) md.Run {
+	run.Bytes = bytes.Trim(run.Bytes, mdutils.Whites)
+	return run
+}
+
+// WriteHTML writes entries as nested <ul> lists of links to the headers.
+func WriteHTML(w io.Writer, entries []*Entry) error {
+	buf := bytes.NewBuffer(nil)
+	writeHTML(buf, entries)
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+func writeHTML(buf *bytes.Buffer, entries []*Entry) {
+	buf.WriteString("<ul>\n")
+	for _, e := range entries {
+		fmt.Fprintf(buf, `<li><a href="#%s">%s</a>`, html.EscapeString(e.ID), html.EscapeString(e.Text))
+		if len(e.Children) > 0 {
+			buf.WriteString("\n")
+			writeHTML(buf, e.Children)
+		}
+		buf.WriteString("</li>\n")
+	}
+	buf.WriteString("</ul>\n")
+}
+
+// WriteMarkdown writes entries as nested Markdown lists of links to the
+// headers.
+func WriteMarkdown(w io.Writer, entries []*Entry) error {
+	buf := bytes.NewBuffer(nil)
+	writeMarkdown(buf, entries, "")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
+
+var mdEscaper = strings.NewReplacer(
+	`\`, `\\`, `[`, `\[`, `]`, `\]`, "`", "\\`", `*`, `\*`, `_`, `\_`,
+	`<`, `\<`, `&`, `\&`)
+
+// idEscaper percent-encodes characters of custom ids which could end the URL
+// of a Markdown link or change its meaning.
+var idEscaper = strings.NewReplacer(
+	`%`, `%25`, `(`, `%28`, `)`, `%29`, `<`, `%3C`, `>`, `%3E`, `\`, `%5C`,
+	`"`, `%22`, `'`, `%27`, `&`, `%26`)
+
+func writeMarkdown(buf *bytes.Buffer, entries []*Entry, indent string) {
+	for _, e := range entries {
+		fmt.Fprintf(buf, "%s* [%s](#%s)\n", indent, mdEscaper.Replace(e.Text), idEscaper.Replace(e.ID))
+		writeMarkdown(buf, e.Children, indent+"  ")
+	}
+}
@@ -0,0 +1,193 @@
+package mdtoc
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/davecgh/go-spew/spew"
+	"github.com/kylelemons/godebug/diff"
+
+	"gopkg.in/akavel/vfmd.v1"
+	"gopkg.in/akavel/vfmd.v1/mdblock"
+	"gopkg.in/akavel/vfmd.v1/x/mdhtml"
+)
+
+const sample = `Title
+=====
+
+Intro.
+
+## First *part*
+
+### Details {#details}
+
+> # Quoted
+
+* # In list
+
+## Second ` + "`part`" + `
+
+# Appendix \[a\]
+
+## First part
+`
+
+func TestParse(test *testing.T) {
+	cases := []struct {
+		opt      Options
+		expected []*Entry
+	}{{
+		Options{},
+		[]*Entry{
+			{Level: 1, Text: "Title", ID: "title", Line: 0, Children: []*Entry{
+				{Level: 2, Text: "First part", ID: "first-part", Line: 5, Children: []*Entry{
+					{Level: 3, Text: "Details", ID: "details", Line: 7},
+				}},
+				{Level: 2, Text: "Second part", ID: "second-part", Line: 13},
+			}},
+			{Level: 1, Text: "Appendix [a]", ID: "appendix-a", Line: 15, Children: []*Entry{
+				{Level: 2, Text: "First part", ID: "first-part-1", Line: 17},
+			}},
+		},
+	}, {
+		Options{Nested: true},
+		[]*Entry{
+			{Level: 1, Text: "Title", ID: "title", Line: 0, Children: []*Entry{
+				{Level: 2, Text: "First part", ID: "first-part", Line: 5, Children: []*Entry{
+					{Level: 3, Text: "Details", ID: "details", Line: 7},
+				}},
+			}},
+			{Level: 1, Text: "Quoted", ID: "quoted", Line: 9},
+			{Level: 1, Text: "In list", ID: "in-list", Line: 11, Children: []*Entry{
+				{Level: 2, Text: "Second part", ID: "second-part", Line: 13},
+			}},
+			{Level: 1, Text: "Appendix [a]", ID: "appendix-a", Line: 15, Children: []*Entry{
+				{Level: 2, Text: "First part", ID: "first-part-1", Line: 17},
+			}},
+		},
+	}}
+	dump := spew.ConfigState{Indent: "  ", DisablePointerAddresses: true}
+	for _, c := range cases {
+		toc, err := Parse(strings.NewReader(sample), c.opt)
+		if err != nil {
+			test.Errorf("options %+v error: %s", c.opt, err)
+			continue
+		}
+		if dump.Sdump(toc) != dump.Sdump(c.expected) {
+			test.Errorf("options %+v expected vs. got DIFF:\n%s",
+				c.opt, diff.Diff(dump.Sdump(c.expected), dump.Sdump(toc)))
+		}
+	}
+}
+
+func TestWrite(test *testing.T) {
+	toc, err := Parse(strings.NewReader(sample), Options{})
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := `<ul>
+<li><a href="#title">Title</a>
+<ul>
+<li><a href="#first-part">First part</a>
+<ul>
+<li><a href="#details">Details</a></li>
+</ul>
+</li>
+<li><a href="#second-part">Second part</a></li>
+</ul>
+</li>
+<li><a href="#appendix-a">Appendix [a]</a>
+<ul>
+<li><a href="#first-part-1">First part</a></li>
+</ul>
+</li>
+</ul>
+`
+	buf := bytes.NewBuffer(nil)
+	err = WriteHTML(buf, toc)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if buf.String() != expected {
+		test.Errorf("HTML expected vs. got DIFF:\n%s", diff.Diff(expected, buf.String()))
+	}
+
+	expected = `* [Title](#title)
+  * [First part](#first-part)
+    * [Details](#details)
+  * [Second part](#second-part)
+* [Appendix \[a\]](#appendix-a)
+  * [First part](#first-part-1)
+`
+	buf.Reset()
+	err = WriteMarkdown(buf, toc)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if buf.String() != expected {
+		test.Errorf("Markdown expected vs. got DIFF:\n%s", diff.Diff(expected, buf.String()))
+	}
+}
+
+func TestIDsMatchHTML(test *testing.T) {
+	prep, _ := vfmd.QuickPrep(strings.NewReader(sample))
+	blocks, err := mdblock.QuickParse(bytes.NewReader(prep), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = mdhtml.Renderer{AutoHeadingID: true}.Render(buf, blocks)
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := []string{}
+	for _, m := range regexp.MustCompile(`id="([^"]*)"`).FindAllStringSubmatch(buf.String(), -1) {
+		expected = append(expected, m[1])
+	}
+	ids := []string{}
+	var flatten func([]*Entry)
+	flatten = func(entries []*Entry) {
+		for _, e := range entries {
+			ids = append(ids, e.ID)
+			flatten(e.Children)
+		}
+	}
+	flatten(Build(blocks, Options{Nested: true}))
+	if strings.Join(ids, " ") != strings.Join(expected, " ") {
+		test.Errorf("expected ids %q, got %q", expected, ids)
+	}
+}
+
+func TestWriteMarkdownIDs(test *testing.T) {
+	toc, err := Parse(strings.NewReader("# A {#a)b}\n\n# B {#c(d\"e'f&h%}\n"), Options{})
+	if err != nil {
+		test.Fatal(err)
+	}
+	buf := bytes.NewBuffer(nil)
+	err = WriteMarkdown(buf, toc)
+	if err != nil {
+		test.Fatal(err)
+	}
+	expected := "* [A](#a%29b)\n* [B](#c%28d%22e%27f%26h%25)\n"
+	if buf.String() != expected {
+		test.Errorf("Markdown expected vs. got DIFF:\n%s", diff.Diff(expected, buf.String()))
+	}
+
+	// The links must be parsed back with the whole ids.
+	blocks, err := mdblock.QuickParse(bytes.NewReader(buf.Bytes()), mdblock.BlocksAndSpans, nil, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	html := bytes.NewBuffer(nil)
+	err = mdhtml.Renderer{}.Render(html, blocks)
+	if err != nil {
+		test.Fatal(err)
+	}
+	for _, href := range []string{`href="#a%29b"`, `href="#c%28d%22e%27f%26h%25"`} {
+		if !strings.Contains(html.String(), href) {
+			test.Errorf("expected %s in HTML:\n%s", href, html.String())
+		}
+	}
+}